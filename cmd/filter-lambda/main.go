@@ -19,13 +19,14 @@ var (
 )
 
 func main() {
-	kong.Parse(cfg,
+	ctx := kong.Parse(cfg,
 		kong.Vars{"version": version}, // bind a var for version
 	)
 
 	flds := lmw.FieldMap{"version": version}
 
-	ps := snsevents.NewProcessor(*cfg, &aws.Config{})
+	ps, err := snsevents.NewProcessor(*cfg, &aws.Config{})
+	ctx.FatalIfErrorf(err)
 
 	ch := lmw.New(
 		raw.New(raw.Fields(flds)),   // raw event logger primarily used during development
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/kong"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/wolfeidau/ssmcache"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/pseudonym"
+)
+
+var (
+	version = "unknown"
+
+	cli struct {
+		Version     kong.VersionFlag
+		KeySSMParam string `env:"PSEUDONYM_KEY_SSM_PARAM" xor:"key" help:"Name of the ssm SecureString parameter containing the key."`
+		Key         string `env:"PSEUDONYM_KEY" xor:"key" help:"The key, used instead of reading it from ssm."`
+
+		Reidentify ReidentifyCmd `cmd:"" help:"Print the value each token was created from."`
+		Token      TokenCmd      `cmd:"" help:"Print the token for each value, used to search the clean feed for a principal."`
+	}
+)
+
+// ReidentifyCmd re-identify the values of one or more tokens
+type ReidentifyCmd struct {
+	Tokens []string `arg:"" help:"Tokens found in the clean feed."`
+}
+
+// Run print each token along with its value
+func (rc *ReidentifyCmd) Run(pn *pseudonym.Pseudonymizer) error {
+	for _, token := range rc.Tokens {
+		value, err := pn.Reidentify(token)
+		if err != nil {
+			return fmt.Errorf("failed to reidentify %s: %w", token, err)
+		}
+
+		fmt.Printf("%s\t%s\n", token, value)
+	}
+
+	return nil
+}
+
+// TokenCmd print the tokens for one or more values
+type TokenCmd struct {
+	Values []string `arg:"" help:"Values such as principal ARNs or source IP addresses."`
+}
+
+// Run print each value along with its token
+func (tc *TokenCmd) Run(pn *pseudonym.Pseudonymizer) error {
+	for _, value := range tc.Values {
+		fmt.Printf("%s\t%s\n", value, pn.Token(value))
+	}
+
+	return nil
+}
+
+// loadKey create the pseudonymizer using the key provided or read from ssm
+func loadKey() (*pseudonym.Pseudonymizer, error) {
+	switch {
+	case cli.Key != "":
+		return pseudonym.New([]byte(cli.Key))
+	case cli.KeySSMParam != "":
+		return pseudonym.NewFromSSM(ssmcache.New(&aws.Config{}), cli.KeySSMParam)
+	default:
+		return nil, fmt.Errorf("either --key-ssm-param or --key is required")
+	}
+}
+
+func main() {
+	ctx := kong.Parse(&cli,
+		kong.Vars{"version": version}, // bind a var for version
+	)
+
+	pn, err := loadKey()
+	ctx.FatalIfErrorf(err)
+
+	err = ctx.Run(pn)
+	ctx.FatalIfErrorf(err)
+}
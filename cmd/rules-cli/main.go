@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/rules"
+)
+
+var (
+	version = "unknown"
+
+	cli struct {
+		Version kong.VersionFlag
+		Migrate MigrateCmd `cmd:"" help:"Print the rules configuration migrated to the latest schema version."`
+		Schema  SchemaCmd  `cmd:"" help:"Print the JSON Schema for the latest rules configuration version."`
+		Lint    LintCmd    `cmd:"" help:"Report rules which are likely mistakes, such as rules shadowed by earlier rules."`
+		Report  ReportCmd  `cmd:"" help:"Print each rule with its status, owner, ticket and description."`
+	}
+)
+
+// MigrateCmd migrate a rules configuration file to the latest schema version
+type MigrateCmd struct {
+	File string `arg:"" type:"existingfile" help:"Path to the rules configuration file."`
+}
+
+// Run print the migrated configuration
+func (mc *MigrateCmd) Run() error {
+	rawCfg, err := ioutil.ReadFile(mc.File)
+	if err != nil {
+		return err
+	}
+
+	out, err := rules.Migrate(string(rawCfg))
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s: %w", mc.File, err)
+	}
+
+	_, err = os.Stdout.Write(out)
+
+	return err
+}
+
+// SchemaCmd print the JSON Schema for the rules configuration
+type SchemaCmd struct{}
+
+// Run print the schema
+func (sc *SchemaCmd) Run() error {
+	out, err := rules.JSONSchema()
+	if err != nil {
+		return fmt.Errorf("failed to generate schema: %w", err)
+	}
+
+	_, err = fmt.Println(string(out))
+
+	return err
+}
+
+// LintCmd lint one or more rules configuration files, these are merged in the order provided
+type LintCmd struct {
+	Files []string `arg:"" type:"existingfile" help:"Paths to the rules configuration files."`
+}
+
+// Run print the findings, returning an error if there are any
+func (lc *LintCmd) Run() error {
+	cfg, err := loadFiles(lc.Files)
+	if err != nil {
+		return err
+	}
+
+	findings := rules.Lint(cfg)
+
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+
+	if len(findings) > 0 {
+		return fmt.Errorf("%d finding(s)", len(findings))
+	}
+
+	return nil
+}
+
+// ReportCmd report the rules in one or more rules configuration files, these are merged in the order provided
+type ReportCmd struct {
+	Files []string `arg:"" type:"existingfile" help:"Paths to the rules configuration files."`
+}
+
+// Run print a table of the rules
+func (rc *ReportCmd) Run() error {
+	cfg, err := loadFiles(rc.Files)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "NAME\tACTION\tMODE\tSTATUS\tEXPIRES\tOWNER\tTICKET\tSOURCE\tDESCRIPTION")
+
+	for _, rule := range cfg.Rules {
+		action := rule.ActionOrDrop()
+		if action == rules.ActionSample {
+			action = fmt.Sprintf("%s %g", action, rule.SampleRate)
+		}
+
+		fmt.Fprintln(tw, strings.Join([]string{
+			rule.Name,
+			action,
+			orDash(rule.Mode),
+			rule.Status(time.Now()),
+			orDash(rule.Expires),
+			orDash(rule.Owner),
+			orDash(rule.Ticket),
+			orDash(rule.Source),
+			orDash(rule.Description),
+		}, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// loadFiles read the files and merge them into a validated configuration
+func loadFiles(files []string) (*rules.Configuration, error) {
+	var docs []*rules.Document
+
+	for _, file := range files {
+		rawCfg, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, &rules.Document{Source: file, Content: string(rawCfg)})
+	}
+
+	cfg, err := rules.Merge(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func main() {
+	ctx := kong.Parse(&cli,
+		kong.Vars{"version": version}, // bind a var for version
+	)
+
+	err := ctx.Run()
+	ctx.FatalIfErrorf(err)
+}
@@ -8,6 +8,8 @@ require (
 	github.com/aws/aws-sdk-go v1.37.19
 	github.com/go-playground/validator/v10 v10.4.1
 	github.com/golang/mock v1.5.0
+	github.com/google/cel-go v0.10.0
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/rs/zerolog v1.20.0
 	github.com/segmentio/encoding v0.2.7
 	github.com/stretchr/testify v1.7.0
@@ -15,6 +17,6 @@ require (
 	github.com/wolfeidau/lambda-go-extras/middleware/raw v1.3.0
 	github.com/wolfeidau/lambda-go-extras/middleware/zerolog v1.3.0
 	github.com/wolfeidau/ssmcache v1.0.0
-	golang.org/x/sys v0.0.0-20210309074719-68d13333faf2 // indirect
-	gopkg.in/yaml.v2 v2.2.8
+	github.com/xeipuuv/gojsonschema v1.2.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -5,17 +5,24 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/encoding/json"
 	"github.com/wolfeidau/ssmcache"
 
 	"github.com/wolfeidau/cloudtrail-log-processor/internal/flags"
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/geoip"
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/pseudonym"
 	"github.com/wolfeidau/cloudtrail-log-processor/internal/rules"
 )
 
@@ -31,6 +38,15 @@ type Copier interface {
 	Copy(ctx context.Context, bucket, key string) error
 }
 
+// configRulesEnv environment variable containing the configuration when using the env source
+const configRulesEnv = "CONFIG_RULES"
+
+// tagsField field added to records which match one or more tag rules
+const tagsField = "x_tags"
+
+// sampleRateField field added to records kept by a sample rule, so downstream tools can scale counts by the rate
+const sampleRateField = "x_sample_rate"
+
 // Cloudtrail cloudtrail document used to store audit records
 type Cloudtrail struct {
 	Records []json.RawMessage
@@ -40,34 +56,225 @@ type Cloudtrail struct {
 type S3Copier struct {
 	s3svc     S3API
 	uploadsvc UploaderAPI
-	cfg       flags.S3Processor
 	ssm       ssmcache.Cache
+	cfg       flags.S3Processor
+	loader    *rules.Loader
+
+	// geo the geoip databases, these are opened when the first file is processed and kept for the life of the lambda
+	geoMu sync.Mutex
+	geo   *geoip.Enricher
 }
 
-// NewProcessor setup a new s3 event processor
-func NewCopier(cfg flags.S3Processor, awscfg *aws.Config) Copier {
+// NewProcessor setup a new s3 event processor, returning an error if the settings of the configuration source
+// or snapshot are missing
+func NewCopier(cfg flags.S3Processor, awscfg *aws.Config) (Copier, error) {
+	source, err := configSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkConfigSettings(cfg, source)
+	if err != nil {
+		return nil, err
+	}
+
 	sess := session.Must(session.NewSession(awscfg))
 
+	ssmsvc := ssmcache.New(awscfg)
+
+	// lists referenced by the rules may be stored in separate ssm parameters or s3 objects
+	src := rules.NewListSource(newConfigSource(cfg, source, sess, awscfg), ssmsvc, s3.New(sess))
+
 	return &S3Copier{
 		s3svc:     s3.New(sess),
 		uploadsvc: s3manager.NewUploader(sess),
+		ssm:       ssmsvc,
 		cfg:       cfg,
-		ssm:       ssmcache.New(awscfg),
+		loader:    rules.NewLoader(src, loaderOptions(cfg, sess)...),
+	}, nil
+}
+
+// configSource return the configuration source selected with CONFIG_SOURCE, when this isn't set the source is
+// inferred from the settings provided, defaulting to a single ssm parameter
+func configSource(cfg flags.S3Processor) (string, error) {
+	if cfg.ConfigSource != "" {
+		return cfg.ConfigSource, nil
+	}
+
+	var inferred []string
+
+	if cfg.ConfigSSMParam != "" {
+		inferred = append(inferred, "ssm")
+	}
+
+	if cfg.ConfigSSMPath != "" {
+		inferred = append(inferred, "ssm_path")
+	}
+
+	if cfg.ConfigS3Bucket != "" || cfg.ConfigS3Key != "" {
+		inferred = append(inferred, "s3")
+	}
+
+	if cfg.ConfigFile != "" {
+		inferred = append(inferred, "file")
+	}
+
+	switch len(inferred) {
+	case 0:
+		return "ssm", nil
+	case 1:
+		return inferred[0], nil
+	default:
+		return "", fmt.Errorf("CONFIG_SOURCE is required as settings are provided for the %s sources", strings.Join(inferred, ", "))
+	}
+}
+
+// checkConfigSettings ensure the settings required by the configuration source and snapshot are provided, so
+// these are reported at startup rather than when the configuration is first loaded
+func checkConfigSettings(cfg flags.S3Processor, source string) error {
+	var missing []string
+
+	require := func(name, value string) {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	switch source {
+	case "ssm":
+		require("CONFIG_SSM_PARAM", cfg.ConfigSSMParam)
+	case "ssm_path":
+		require("CONFIG_SSM_PATH", cfg.ConfigSSMPath)
+	case "s3":
+		require("CONFIG_S3_BUCKET", cfg.ConfigS3Bucket)
+		require("CONFIG_S3_KEY", cfg.ConfigS3Key)
+	case "file":
+		require("CONFIG_FILE", cfg.ConfigFile)
+	case "env":
+		require(configRulesEnv, os.Getenv(configRulesEnv))
+	default:
+		return fmt.Errorf("invalid CONFIG_SOURCE %q, must be one of ssm, ssm_path, s3, file, env", source)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is required for the %s config source", strings.Join(missing, ", "), source)
+	}
+
+	if cfg.ConfigSnapshotS3Bucket != "" || cfg.ConfigSnapshotS3Key != "" {
+		require("CONFIG_SNAPSHOT_S3_BUCKET", cfg.ConfigSnapshotS3Bucket)
+		require("CONFIG_SNAPSHOT_S3_KEY", cfg.ConfigSnapshotS3Key)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is required for the s3 config snapshot", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// loaderOptions configure the fallback used when the configuration can't be loaded
+func loaderOptions(cfg flags.S3Processor, sess *session.Session) []rules.LoaderOption {
+	opts := []rules.LoaderOption{rules.WithFallbackPolicy(cfg.ConfigFallback)}
+
+	switch {
+	case cfg.ConfigSnapshotS3Bucket != "":
+		opts = append(opts, rules.WithSnapshot(rules.NewS3Snapshot(s3.New(sess), cfg.ConfigSnapshotS3Bucket, cfg.ConfigSnapshotS3Key)))
+	case cfg.ConfigSnapshotFile != "":
+		opts = append(opts, rules.WithSnapshot(rules.NewFileSnapshot(cfg.ConfigSnapshotFile)))
+	}
+
+	return opts
+}
+
+// newConfigSource create the configuration source selected in the flags
+func newConfigSource(cfg flags.S3Processor, source string, sess *session.Session, awscfg *aws.Config) rules.ConfigSource {
+	switch source {
+	case "ssm_path":
+		return rules.NewSSMPathSource(ssm.New(sess), cfg.ConfigSSMPath)
+	case "s3":
+		return rules.NewS3Source(s3.New(sess), cfg.ConfigS3Bucket, cfg.ConfigS3Key)
+	case "file":
+		return rules.NewFileSource(cfg.ConfigFile)
+	case "env":
+		return rules.NewEnvSource(configRulesEnv)
+	default:
+		return rules.NewSSMSource(ssmcache.New(awscfg), cfg.ConfigSSMParam)
 	}
 }
 
 func (cp *S3Copier) Copy(ctx context.Context, bucket, key string) error {
-	rulesCfg, err := rules.LoadFromSSMAndValidate(ctx, cp.ssm, cp.cfg.ConfigSSMParam)
+	ruleSet, err := cp.loader.Load(ctx)
 	if err != nil {
 		log.Ctx(ctx).Error().Err(err).Msg("Unmarshal")
 		return err
 	}
 
-	return cp.processFile(ctx, bucket, key, rulesCfg)
+	stage, err := cp.pseudonymStage()
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("pseudonym key")
+		return err
+	}
+
+	geo, err := cp.geoStage(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("geoip databases")
+		return err
+	}
+
+	return cp.processFile(ctx, bucket, key, ruleSet, &stages{pseudonym: stage, geo: geo})
+}
+
+// pseudonymStage load the key used to pseudonymize the fields identifying principals, returning nil if
+// pseudonymization isn't configured
+func (cp *S3Copier) pseudonymStage() (*pseudonymStage, error) {
+	if cp.cfg.PseudonymKeySSMParam == "" {
+		return nil, nil
+	}
+
+	fields := cp.cfg.PseudonymFields
+	if len(fields) == 0 {
+		fields = pseudonym.DefaultFields
+	}
+
+	paths, err := pseudonym.ParseFields(fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pseudonym fields: %w", err)
+	}
+
+	pn, err := pseudonym.NewFromSSM(cp.ssm, cp.cfg.PseudonymKeySSMParam)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pseudonymStage{pn: pn, paths: paths}, nil
+}
+
+// geoStage open the geoip databases used to enrich the source IP address of retained records, these are only
+// read once so are loaded at cold start, returning nil if geoip enrichment isn't configured
+func (cp *S3Copier) geoStage(ctx context.Context) (*geoip.Enricher, error) {
+	if cp.cfg.GeoIPCityDB == "" && cp.cfg.GeoIPASNDB == "" {
+		return nil, nil
+	}
+
+	cp.geoMu.Lock()
+	defer cp.geoMu.Unlock()
+
+	if cp.geo != nil {
+		return cp.geo, nil
+	}
+
+	geo, err := geoip.Open(ctx, cp.s3svc, cp.cfg.GeoIPCityDB, cp.cfg.GeoIPASNDB)
+	if err != nil {
+		return nil, err
+	}
+
+	cp.geo = geo
+
+	return geo, nil
 }
 
-func (cp *S3Copier) processFile(ctx context.Context, bucket, key string, rulesCfg *rules.Configuration) error {
-	inct, err := cp.downloadCloudtrail(ctx, bucket, key)
+func (cp *S3Copier) processFile(ctx context.Context, bucket, key string, ruleSet *rules.RuleSet, st *stages) error {
+	inct, bytesIn, err := cp.downloadCloudtrail(ctx, bucket, key)
 	if err != nil {
 		return fmt.Errorf("failed to download and decode source JSON file: %w", err)
 	}
@@ -75,7 +282,7 @@ func (cp *S3Copier) processFile(ctx context.Context, bucket, key string, rulesCf
 	log.Ctx(ctx).Info().Int("input", len(inct.Records)).Msg("completed")
 
 	// filter events
-	outct, err := filterRecords(ctx, inct, rulesCfg)
+	outct, err := filterRecords(ctx, inct, ruleSet, st)
 	if err != nil {
 		return fmt.Errorf("failed to filter records: %w", err)
 	}
@@ -96,91 +303,358 @@ func (cp *S3Copier) processFile(ctx context.Context, bucket, key string, rulesCf
 	}
 
 	if uj.Error != nil {
-		return fmt.Errorf("failed to complete upload job: %w", err)
+		return fmt.Errorf("failed to complete upload job: %w", uj.Error)
 	}
 
 	log.Ctx(ctx).Info().
 		Str("path", fmt.Sprintf("s3://%s/%s", cp.cfg.CloudtrailOutputBucketName, key)).
 		Int("input", len(inct.Records)).
 		Int("output", len(outct.Records)).
+		Int64("bytes_in", bytesIn).
+		Int64("bytes_out", uj.Bytes).
 		Str("req", uploadRes.UploadID).
 		Msg("uploaded file")
 
 	return nil
 }
 
-func (cp *S3Copier) downloadCloudtrail(ctx context.Context, bucket, key string) (*Cloudtrail, error) {
+// downloadCloudtrail download and decode the cloudtrail file, returning the records along with the number of bytes read
+func (cp *S3Copier) downloadCloudtrail(ctx context.Context, bucket, key string) (*Cloudtrail, int64, error) {
 	res, err := cp.s3svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	defer func() {
 		_ = res.Body.Close()
 	}()
 
+	body := &countingReader{r: res.Body}
+
 	inct := new(Cloudtrail)
-	decoder := json.NewDecoder(res.Body)
+	decoder := json.NewDecoder(body)
 	decoder.UseNumber()
 	decoder.ZeroCopy()
 
 	err = decoder.Decode(inct)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return inct, nil
+	return inct, body.n, nil
 }
 
-func filterRecords(ctx context.Context, inct *Cloudtrail, rulesCfg *rules.Configuration) (*Cloudtrail, error) {
+// stages the optional stages applied to retained records after the rules, a nil stage is skipped
+type stages struct {
+	pseudonym *pseudonymStage
+	geo       *geoip.Enricher
+}
+
+// pseudonymStage replaces the fields identifying principals in retained records with pseudonyms
+type pseudonymStage struct {
+	pn    *pseudonym.Pseudonymizer
+	paths []rules.Path
+}
+
+// apply pseudonymize the record, returning true if it was changed, a nil stage leaves the record unchanged
+func (ps *pseudonymStage) apply(rec map[string]interface{}) bool {
+	if ps == nil {
+		return false
+	}
+
+	return ps.pn.Apply(rec, ps.paths)
+}
+
+func filterRecords(ctx context.Context, inct *Cloudtrail, ruleSet *rules.RuleSet, st *stages) (*Cloudtrail, error) {
+	if st == nil {
+		st = new(stages)
+	}
+
+	rf := &recordFilter{
+		ruleSet:  ruleSet,
+		stages:   st,
+		hits:     make(map[string]int),
+		failures: make(map[string]*expressionFailures),
+	}
+
 	outct := new(Cloudtrail)
 
 	outct.Records = inct.Records[:0]
-	rec := make(map[string]interface{})
 
 	for _, raw := range inct.Records {
-		err := json.Unmarshal(raw, &rec)
+		// a new map is required for each record, otherwise fields from the previous record are retained
+		rec := make(map[string]interface{})
+
+		// numbers are retained as json.Number so records which are annotated are encoded without loss
+		_, err := json.Parse(raw, &rec, json.UseNumber)
 		if err != nil {
 			return nil, fmt.Errorf("unmarshal record failed: %w", err)
 		}
 
-		log.Ctx(ctx).Debug().Fields(map[string]interface{}{
-			"eventName":          rec["eventName"],
-			"eventSource":        rec["eventSource"],
-			"awsRegion":          rec["awsRegion"],
-			"recipientAccountId": rec["recipientAccountId"],
-		}).Msg("eval record")
-
-		match, err := rulesCfg.EvalRules(rec)
+		dec, err := rf.evaluate(ctx, rec)
 		if err != nil {
 			return nil, err
 		}
-		// because we are using the rules to filter records a match means drop
-		if match {
+
+		if dec.Action == rules.ActionDrop {
 			continue // next record
 		}
 
+		raw, err = rf.retain(rec, raw, dec)
+		if err != nil {
+			return nil, err
+		}
+
 		outct.Records = append(outct.Records, raw)
 	}
 
+	logRuleHits(ctx, ruleSet, rf.hits)
+	logExpressionFailures(ctx, rf.failures)
+
 	return outct, nil
 }
 
+// recordFilter applies the rules and stages to the records of a file, counting the records matched by each rule
+// and the expressions which failed to evaluate so these are logged once per file
+type recordFilter struct {
+	ruleSet *rules.RuleSet
+	stages  *stages
+
+	// hits the number of records matched by each rule, logged with the rule metadata for auditing
+	hits map[string]int
+
+	// failures the number of records each rule or transform expression failed to evaluate against, a misspelled
+	// field never matches so these are logged to make it visible
+	failures map[string]*expressionFailures
+}
+
+// evaluate decide the action for the record, counting the rules which matched it
+func (rf *recordFilter) evaluate(ctx context.Context, rec map[string]interface{}) (*rules.Decision, error) {
+	dec, err := rf.ruleSet.Evaluate(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Debug().Fields(recordFields(rec)).Str("action", dec.Action).Str("rule", dec.Rule).Msg("eval record")
+
+	if dec.Rule != "" {
+		rf.hits[dec.Rule]++
+	}
+
+	countExpressionErrors(rf.failures, "rule", dec.ExpressionErrors)
+
+	for _, tag := range dec.Tags {
+		rf.hits[tag]++
+	}
+
+	// rules in dry run mode don't change the action, so log the records they would have affected for review
+	for _, name := range dec.DryRun {
+		rf.hits[name]++
+
+		log.Ctx(ctx).Info().Fields(recordFields(rec)).Str("action", dec.Action).Object("rule", rf.ruleSet.Rule(name)).
+			Msg("dry run rule matched record")
+	}
+
+	return dec, nil
+}
+
+// retain apply the stages to the retained record, the raw record is returned unless it was changed or annotated
+func (rf *recordFilter) retain(rec map[string]interface{}, raw json.RawMessage, dec *rules.Decision) (json.RawMessage, error) {
+	// look up the accounts and source address before the transforms, pseudonymization and projection, which
+	// may remove or replace the values
+	enriched := rf.enrich(rec)
+
+	located, err := rf.locate(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := rf.transform(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	pseudonymized := rf.pseudonymize(rec)
+
+	rec, projected := rf.project(rec)
+
+	changed := transformed || pseudonymized || projected || len(dec.Tags) > 0 || dec.SampleRate > 0
+
+	if !changed && len(enriched) == 0 && len(located) == 0 {
+		return raw, nil
+	}
+
+	annotate(rec, dec, enriched, located)
+
+	raw, err = json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("marshal changed record failed: %w", err)
+	}
+
+	return raw, nil
+}
+
+// enrich return the fields describing the account which received the event and the account of the principal
+func (rf *recordFilter) enrich(rec map[string]interface{}) map[string]interface{} {
+	return rf.ruleSet.Enrich(rec)
+}
+
+// locate return the fields describing the location and network of the source address
+func (rf *recordFilter) locate(rec map[string]interface{}) (map[string]interface{}, error) {
+	return rf.stages.geo.Enrich(rec)
+}
+
+// transform change the fields of the record, such as removing or masking sensitive values, returning true if
+// any transform was applied
+func (rf *recordFilter) transform(rec map[string]interface{}) (bool, error) {
+	transformed, exprErrs, err := rf.ruleSet.Transform(rec)
+	if err != nil {
+		return false, err
+	}
+
+	countExpressionErrors(rf.failures, "transform", exprErrs)
+
+	return len(transformed) > 0, nil
+}
+
+// pseudonymize replace the fields identifying principals once the transforms have been applied, returning true
+// if the record was changed
+func (rf *recordFilter) pseudonymize(rec map[string]interface{}) bool {
+	return rf.stages.pseudonym.apply(rec)
+}
+
+// project keep only the configured fields, before the enrichment and annotations are added so these are always kept
+func (rf *recordFilter) project(rec map[string]interface{}) (map[string]interface{}, bool) {
+	return rf.ruleSet.Project(rec)
+}
+
+// annotate add the fields describing the accounts, location and network of the record, along with the names of the
+// tag rules which matched and the fraction of matching records which were kept
+func annotate(rec map[string]interface{}, dec *rules.Decision, fields ...map[string]interface{}) {
+	for _, f := range fields {
+		for name, value := range f {
+			rec[name] = value
+		}
+	}
+
+	if len(dec.Tags) > 0 {
+		rec[tagsField] = dec.Tags
+	}
+
+	if dec.SampleRate > 0 {
+		rec[sampleRateField] = dec.SampleRate
+	}
+}
+
+// recordFields the fields of the record included in logs to identify it
+func recordFields(rec map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"eventID":            rec["eventID"],
+		"eventName":          rec["eventName"],
+		"eventSource":        rec["eventSource"],
+		"awsRegion":          rec["awsRegion"],
+		"recipientAccountId": rec["recipientAccountId"],
+	}
+}
+
+// logRuleHits log the number of records in the file matched by each rule, along with the metadata of the rule
+func logRuleHits(ctx context.Context, ruleSet *rules.RuleSet, hits map[string]int) {
+	names := make([]string, 0, len(hits))
+	for name := range hits {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		log.Ctx(ctx).Info().Object("rule", ruleSet.Rule(name)).Int("records", hits[name]).Msg("rule matched")
+	}
+}
+
+// expressionFailures the number of records a rule or transform expression failed to evaluate against
+type expressionFailures struct {
+	kind    string
+	name    string
+	records int
+	err     error
+}
+
+func countExpressionErrors(failures map[string]*expressionFailures, kind string, exprErrs rules.ExpressionErrors) {
+	for name, err := range exprErrs {
+		key := kind + "/" + name
+
+		ef, ok := failures[key]
+		if !ok {
+			ef = &expressionFailures{kind: kind, name: name}
+			failures[key] = ef
+		}
+
+		ef.records++
+		ef.err = err
+	}
+}
+
+// logExpressionFailures log the rules and transforms which were skipped as their expression failed to evaluate,
+// along with the last error, such as a field missing from the record
+func logExpressionFailures(ctx context.Context, failures map[string]*expressionFailures) {
+	keys := make([]string, 0, len(failures))
+	for key := range failures {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		ef := failures[key]
+		log.Ctx(ctx).Warn().Str(ef.kind, ef.name).Int("records", ef.records).Err(ef.err).Msg("expression evaluation failed")
+	}
+}
+
 // helps track encoding / streaming errors for a go routine
 type uploadJob struct {
 	Error error
+	// Bytes the number of bytes of JSON written, before it is compressed
+	Bytes int64
 }
 
 // streams json in the background when the writer is consumed
 func (uj *uploadJob) Start(pwr io.WriteCloser, out interface{}) {
 	gw := gzip.NewWriter(pwr)
+	cw := &countingWriter{w: gw}
 
-	encoder := json.NewEncoder(gw)
+	encoder := json.NewEncoder(cw)
 	encoder.SetSortMapKeys(false)
 	uj.Error = encoder.Encode(out)
+	uj.Bytes = cw.n
 	_ = gw.Close()
 	_ = pwr.Close()
 }
+
+// countingReader counts the bytes read from the reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+// countingWriter counts the bytes written to the writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
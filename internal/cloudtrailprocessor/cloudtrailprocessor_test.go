@@ -2,18 +2,27 @@ package cloudtrailprocessor
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/segmentio/encoding/json"
 	"github.com/stretchr/testify/require"
 
 	"github.com/wolfeidau/cloudtrail-log-processor/internal/flags"
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/geoip"
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/rules"
 	"github.com/wolfeidau/cloudtrail-log-processor/mocks"
 )
 
@@ -70,6 +79,584 @@ func TestProcessor_Handler(t *testing.T) {
 	}
 }
 
+func TestNewCopierConfigSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     flags.S3Processor
+		want    string
+		wantErr string
+	}{
+		{
+			name: "should default to a single ssm parameter",
+			cfg:  flags.S3Processor{ConfigSSMParam: "/config/rules"},
+			want: "ssm",
+		},
+		{
+			name: "should infer ssm path",
+			cfg:  flags.S3Processor{ConfigSSMPath: "/config/rules"},
+			want: "ssm_path",
+		},
+		{
+			name: "should infer s3",
+			cfg:  flags.S3Processor{ConfigS3Bucket: "config-bucket", ConfigS3Key: "rules.yaml"},
+			want: "s3",
+		},
+		{
+			name: "should use the selected source",
+			cfg:  flags.S3Processor{ConfigSource: "file", ConfigSSMParam: "/config/rules", ConfigFile: "rules.yaml"},
+			want: "file",
+		},
+		{
+			name:    "should reject settings for more than one source",
+			cfg:     flags.S3Processor{ConfigSSMParam: "/config/rules", ConfigSSMPath: "/config/rules"},
+			wantErr: "CONFIG_SOURCE is required as settings are provided for the ssm, ssm_path sources",
+		},
+		{
+			name:    "should reject missing ssm parameter",
+			cfg:     flags.S3Processor{},
+			wantErr: "CONFIG_SSM_PARAM is required for the ssm config source",
+		},
+		{
+			name:    "should reject missing s3 key",
+			cfg:     flags.S3Processor{ConfigS3Bucket: "config-bucket"},
+			wantErr: "CONFIG_S3_KEY is required for the s3 config source",
+		},
+		{
+			name:    "should reject unknown source",
+			cfg:     flags.S3Processor{ConfigSource: "dynamodb"},
+			wantErr: `invalid CONFIG_SOURCE "dynamodb", must be one of ssm, ssm_path, s3, file, env`,
+		},
+		{
+			name:    "should reject missing snapshot key",
+			cfg:     flags.S3Processor{ConfigSSMParam: "/config/rules", ConfigSnapshotS3Bucket: "snapshot-bucket"},
+			wantErr: "CONFIG_SNAPSHOT_S3_KEY is required for the s3 config snapshot",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCopier(tt.cfg, &aws.Config{Region: aws.String("us-east-1")})
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+
+			got, err := configSource(tt.cfg)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCopyLogsBytes(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := flags.S3Processor{ConfigSSMParam: "/config/whatever", CloudtrailOutputBucketName: "outputbucket"}
+
+	input := `{"Records":[{"eventName":"Decrypt","eventSource":"kms.amazonaws.com"},{"eventName":"ConsoleLogin"}]}`
+
+	ssm := mocks.NewMockCache(ctrl)
+	s3svc := mocks.NewMockS3API(ctrl)
+	uploadsvc := mocks.NewMockUploaderAPI(ctrl)
+
+	ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlConfig, nil)
+
+	s3svc.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&s3.GetObjectOutput{Body: aws.ReadSeekCloser(bytes.NewBufferString(input))}, nil)
+
+	var uploaded []byte
+
+	uploadsvc.EXPECT().UploadWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ aws.Context, in *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+			gr, err := gzip.NewReader(in.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			uploaded, err = ioutil.ReadAll(gr)
+			if err != nil {
+				return nil, err
+			}
+
+			return &s3manager.UploadOutput{UploadID: "test"}, nil
+		})
+
+	cp := &S3Copier{
+		cfg:       cfg,
+		loader:    rules.NewLoader(rules.NewSSMSource(ssm, cfg.ConfigSSMParam)),
+		s3svc:     s3svc,
+		uploadsvc: uploadsvc,
+	}
+
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf).Level(zerolog.InfoLevel)
+	ctx := logger.WithContext(context.TODO())
+
+	err := cp.Copy(ctx, "testbucket", "test")
+	assert.NoError(err)
+	assert.Equal(`{"Records":[{"eventName":"ConsoleLogin"}]}`+"\n", string(uploaded))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.JSONEq(fmt.Sprintf(`{"level":"info","path":"s3://outputbucket/test","input":2,"output":1,"bytes_in":%d,"bytes_out":%d,`+
+		`"req":"test","message":"uploaded file"}`, len(input), len(uploaded)), lines[len(lines)-1])
+}
+
+func TestFilterRecords(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+rules:
+  - name: drop_account
+    matches:
+    - field_name: recipientAccountId
+      op: equals
+      value: "123456789012"
+`)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventName":"Decrypt","recipientAccountId":"123456789012"}`),
+		json.RawMessage(`{"eventName":"ConsoleLogin"}`),
+		json.RawMessage(`{"eventName":"Decrypt","recipientAccountId":"210987654321"}`),
+	}}
+
+	outct, err := filterRecords(context.TODO(), inct, ruleSet, nil)
+	assert.NoError(err)
+	assert.Len(outct.Records, 2)
+	assert.JSONEq(`{"eventName":"ConsoleLogin"}`, string(outct.Records[0]))
+	assert.JSONEq(`{"eventName":"Decrypt","recipientAccountId":"210987654321"}`, string(outct.Records[1]))
+}
+
+func TestFilterRecordsActions(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+default_action: drop
+rules:
+  - name: tag_root
+    action: tag
+    matches:
+    - field_name: userIdentity.type
+      op: equals
+      value: Root
+  - name: keep_iam
+    action: keep
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: iam.amazonaws.com
+`)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventSource":"iam.amazonaws.com","eventName":"CreateRole"}`),
+		json.RawMessage(`{"eventSource":"s3.amazonaws.com","eventName":"GetObject"}`),
+		json.RawMessage(`{"eventSource":"s3.amazonaws.com","eventName":"PutBucketPolicy","userIdentity":{"type":"Root"},"apiVersion":20060301}`),
+	}}
+
+	outct, err := filterRecords(context.TODO(), inct, ruleSet, nil)
+	assert.NoError(err)
+	assert.Len(outct.Records, 2)
+	assert.JSONEq(`{"eventSource":"iam.amazonaws.com","eventName":"CreateRole"}`, string(outct.Records[0]))
+	assert.JSONEq(`{"eventSource":"s3.amazonaws.com","eventName":"PutBucketPolicy","userIdentity":{"type":"Root"},"apiVersion":20060301,`+
+		`"x_tags":["tag_root"]}`, string(outct.Records[1]))
+}
+
+func TestFilterRecordsSample(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+rules:
+  - name: sample_get_object
+    action: sample
+    sample_rate: 0.5
+    matches:
+    - field_name: eventName
+      op: equals
+      value: GetObject
+`)
+	assert.NoError(err)
+
+	records := make([]json.RawMessage, 100)
+	for i := range records {
+		records[i] = json.RawMessage(fmt.Sprintf(`{"eventID":"%d","eventName":"GetObject"}`, i))
+	}
+
+	outct, err := filterRecords(context.TODO(), &Cloudtrail{Records: append([]json.RawMessage{}, records...)}, ruleSet, nil)
+	assert.NoError(err)
+	assert.NotEmpty(outct.Records)
+	assert.Less(len(outct.Records), len(records))
+
+	for _, raw := range outct.Records {
+		rec := make(map[string]interface{})
+		assert.NoError(json.Unmarshal(raw, &rec))
+		assert.Equal(0.5, rec["x_sample_rate"])
+	}
+
+	// reprocessing the same records keeps the same ones
+	again, err := filterRecords(context.TODO(), &Cloudtrail{Records: append([]json.RawMessage{}, records...)}, ruleSet, nil)
+	assert.NoError(err)
+	assert.Equal(outct.Records, again.Records)
+}
+
+func TestFilterRecordsTransforms(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+rules:
+  - name: tag_ssm
+    action: tag
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: ssm.amazonaws.com
+transforms:
+  - name: ssm_parameter_values
+    matches:
+    - field_name: eventName
+      op: equals
+      value: PutParameter
+    fields:
+    - path: requestParameters.value
+      op: remove
+    - path: requestParameters.name
+      op: mask
+      keep_last: 3
+`)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventSource":"ssm.amazonaws.com","eventName":"PutParameter","requestParameters":{"name":"/app/db","value":"secret","overwrite":true}}`),
+		json.RawMessage(`{"eventSource":"s3.amazonaws.com","eventName":"GetObject","apiVersion":20060301}`),
+	}}
+
+	outct, err := filterRecords(context.TODO(), inct, ruleSet, nil)
+	assert.NoError(err)
+	assert.Len(outct.Records, 2)
+	assert.JSONEq(`{"eventSource":"ssm.amazonaws.com","eventName":"PutParameter","requestParameters":{"name":"****/db","overwrite":true},`+
+		`"x_tags":["tag_ssm"]}`, string(outct.Records[0]))
+	assert.Equal(`{"eventSource":"s3.amazonaws.com","eventName":"GetObject","apiVersion":20060301}`, string(outct.Records[1]))
+}
+
+func TestFilterRecordsProjection(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+rules:
+  - name: tag_root
+    action: tag
+    matches:
+    - field_name: userIdentity.type
+      op: equals
+      value: Root
+projection:
+  fields: [eventName, eventSource, userIdentity.type]
+  event_sources:
+    s3.amazonaws.com: [eventName, requestParameters.bucketName]
+`)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventName":"ConsoleLogin","eventSource":"signin.amazonaws.com","userIdentity":{"type":"Root","arn":"arn:aws:iam::123456789012:root"},"awsRegion":"us-east-1"}`),
+		json.RawMessage(`{"eventName":"GetObject","eventSource":"s3.amazonaws.com","requestParameters":{"bucketName":"testbucket","key":"test"}}`),
+	}}
+
+	outct, err := filterRecords(context.TODO(), inct, ruleSet, nil)
+	assert.NoError(err)
+	assert.Len(outct.Records, 2)
+	assert.JSONEq(`{"eventName":"ConsoleLogin","eventSource":"signin.amazonaws.com","userIdentity":{"type":"Root"},"x_tags":["tag_root"]}`, string(outct.Records[0]))
+	assert.JSONEq(`{"eventName":"GetObject","requestParameters":{"bucketName":"testbucket"}}`, string(outct.Records[1]))
+}
+
+func TestFilterRecordsEnrichment(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+rules: []
+projection:
+  fields: [eventName, userIdentity.type]
+enrichment:
+  accounts:
+    rows:
+      "123456789012": {name: prod, environment: production, owner_team: platform}
+      "210987654321": {name: ci, environment: tooling}
+`)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventName":"AssumeRole","recipientAccountId":"123456789012","userIdentity":{"type":"AWSAccount","accountId":"210987654321"}}`),
+		json.RawMessage(`{"eventName":"ConsoleLogin","recipientAccountId":"999999999999","userIdentity":{"type":"Root"}}`),
+	}}
+
+	outct, err := filterRecords(context.TODO(), inct, ruleSet, nil)
+	assert.NoError(err)
+	assert.Len(outct.Records, 2)
+	assert.JSONEq(`{"eventName":"AssumeRole","userIdentity":{"type":"AWSAccount"},`+
+		`"x_account":{"name":"prod","environment":"production","owner_team":"platform"},`+
+		`"x_principal_account":{"name":"ci","environment":"tooling"}}`, string(outct.Records[0]))
+	assert.JSONEq(`{"eventName":"ConsoleLogin","userIdentity":{"type":"Root"}}`, string(outct.Records[1]))
+}
+
+func TestFilterRecordsPseudonymizes(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ssm := mocks.NewMockCache(ctrl)
+	ssm.EXPECT().GetKey("/config/pseudonym_key", true).Return("0123456789abcdef0123456789abcdef", nil)
+
+	cp := &S3Copier{ssm: ssm, cfg: flags.S3Processor{PseudonymKeySSMParam: "/config/pseudonym_key"}}
+
+	stage, err := cp.pseudonymStage()
+	assert.NoError(err)
+
+	ruleSet, err := rules.LoadAndValidate(yamlConfig)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventName":"ConsoleLogin","userIdentity":{"type":"IAMUser","arn":"arn:aws:iam::123456789012:user/alice"},"sourceIPAddress":"10.0.0.1"}`),
+		json.RawMessage(`{"eventName":"Decrypt","eventSource":"kms.amazonaws.com"}`),
+	}}
+
+	outct, err := filterRecords(context.TODO(), inct, ruleSet, &stages{pseudonym: stage})
+	assert.NoError(err)
+	assert.Len(outct.Records, 1)
+
+	assert.JSONEq(fmt.Sprintf(`{"eventName":"ConsoleLogin","userIdentity":{"type":"IAMUser","arn":%q},"sourceIPAddress":%q}`,
+		stage.pn.Token("arn:aws:iam::123456789012:user/alice"), stage.pn.Token("10.0.0.1")), string(outct.Records[0]))
+}
+
+func TestCopyPseudonymKeyError(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := flags.S3Processor{ConfigSSMParam: "/config/whatever", PseudonymKeySSMParam: "/config/pseudonym_key"}
+
+	ssm := mocks.NewMockCache(ctrl)
+	ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlConfig, nil)
+	ssm.EXPECT().GetKey("/config/pseudonym_key", true).Return("short", nil)
+
+	cp := &S3Copier{ssm: ssm, cfg: cfg, loader: rules.NewLoader(rules.NewSSMSource(ssm, cfg.ConfigSSMParam))}
+
+	err := cp.Copy(context.TODO(), "testbucket", "test")
+	assert.EqualError(err, "pseudonym key must be at least 32 bytes")
+}
+
+func TestCopyGeoIPError(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := flags.S3Processor{ConfigSSMParam: "/config/whatever", GeoIPCityDB: "s3://geoip-bucket/GeoLite2-City.mmdb"}
+
+	ssm := mocks.NewMockCache(ctrl)
+	ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlConfig, nil)
+
+	s3svc := mocks.NewMockS3API(ctrl)
+	s3svc.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String("geoip-bucket"),
+		Key:    aws.String("GeoLite2-City.mmdb"),
+	}).Return(nil, errors.New("access denied"))
+
+	cp := &S3Copier{s3svc: s3svc, ssm: ssm, cfg: cfg, loader: rules.NewLoader(rules.NewSSMSource(ssm, cfg.ConfigSSMParam))}
+
+	err := cp.Copy(context.TODO(), "testbucket", "test")
+	assert.EqualError(err, "failed to open city database: access denied")
+}
+
+// cityReader returns the same location for every address
+type cityReader struct {
+	country, city string
+}
+
+func (cr cityReader) Lookup(_ net.IP, result interface{}) error {
+	rec := result.(*geoip.City)
+	rec.Country.ISOCode = cr.country
+	rec.City.Names = map[string]string{"en": cr.city}
+
+	return nil
+}
+
+func TestFilterRecordsGeoIP(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ssm := mocks.NewMockCache(ctrl)
+	ssm.EXPECT().GetKey("/config/pseudonym_key", true).Return("0123456789abcdef0123456789abcdef", nil)
+
+	cp := &S3Copier{ssm: ssm, cfg: flags.S3Processor{
+		PseudonymKeySSMParam: "/config/pseudonym_key",
+		PseudonymFields:      []string{"sourceIPAddress"},
+	}}
+
+	stage, err := cp.pseudonymStage()
+	assert.NoError(err)
+
+	ruleSet, err := rules.LoadAndValidate(yamlConfig)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventName":"ConsoleLogin","sourceIPAddress":"203.0.113.10"}`),
+		json.RawMessage(`{"eventName":"RunInstances","sourceIPAddress":"autoscaling.amazonaws.com"}`),
+	}}
+
+	// the address is looked up before it is pseudonymized
+	outct, err := filterRecords(context.TODO(), inct, ruleSet, &stages{pseudonym: stage, geo: geoip.New(cityReader{country: "AU", city: "Sydney"}, nil)})
+	assert.NoError(err)
+	assert.Len(outct.Records, 2)
+	assert.JSONEq(fmt.Sprintf(`{"eventName":"ConsoleLogin","sourceIPAddress":%q,"x_geo":{"country":"AU","city":"Sydney"}}`,
+		stage.pn.Token("203.0.113.10")), string(outct.Records[0]))
+	assert.JSONEq(fmt.Sprintf(`{"eventName":"RunInstances","sourceIPAddress":%q}`,
+		stage.pn.Token("autoscaling.amazonaws.com")), string(outct.Records[1]))
+}
+
+func TestFilterRecordsLogsRuleHits(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+rules:
+  - name: kms_decrypt
+    description: Decrypt calls made by services
+    owner: security-team
+    ticket: SEC-123
+    matches:
+    - field_name: eventName
+      op: equals
+      value: Decrypt
+`)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventName":"Decrypt"}`),
+		json.RawMessage(`{"eventName":"Decrypt"}`),
+		json.RawMessage(`{"eventName":"ConsoleLogin"}`),
+	}}
+
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf).Level(zerolog.InfoLevel)
+	ctx := logger.WithContext(context.TODO())
+
+	_, err = filterRecords(ctx, inct, ruleSet, nil)
+	assert.NoError(err)
+	assert.JSONEq(`{"level":"info","rule":{"name":"kms_decrypt","action":"drop","description":"Decrypt calls made by services",`+
+		`"owner":"security-team","ticket":"SEC-123"},"records":2,"message":"rule matched"}`, buf.String())
+}
+
+func TestFilterRecordsLogsExpressionFailures(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+rules:
+  - name: cross_account
+    action: keep
+    when: record.userIdentty.accountId != record.recipientAccountId
+default_action: drop
+`)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventName":"Decrypt","userIdentity":{"accountId":"111111111111"},"recipientAccountId":"222222222222"}`),
+		json.RawMessage(`{"eventName":"ConsoleLogin","userIdentity":{"accountId":"111111111111"},"recipientAccountId":"222222222222"}`),
+	}}
+
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf).Level(zerolog.WarnLevel)
+	ctx := logger.WithContext(context.TODO())
+
+	outct, err := filterRecords(ctx, inct, ruleSet, nil)
+	assert.NoError(err)
+	assert.Len(outct.Records, 0)
+	assert.JSONEq(`{"level":"warn","rule":"cross_account","records":2,`+
+		`"error":"when expression failed: no such key: userIdentty","message":"expression evaluation failed"}`, buf.String())
+}
+
+func TestFilterRecordsDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	ruleSet, err := rules.LoadAndValidate(`
+version: 2
+rules:
+  - name: drop_decrypt
+    mode: dry_run
+    matches:
+    - field_name: eventName
+      op: equals
+      value: Decrypt
+`)
+	assert.NoError(err)
+
+	inct := &Cloudtrail{Records: []json.RawMessage{
+		json.RawMessage(`{"eventID":"1","eventName":"Decrypt"}`),
+		json.RawMessage(`{"eventID":"2","eventName":"ConsoleLogin"}`),
+	}}
+
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf).Level(zerolog.InfoLevel)
+	ctx := logger.WithContext(context.TODO())
+
+	outct, err := filterRecords(ctx, inct, ruleSet, nil)
+	assert.NoError(err)
+	assert.Len(outct.Records, 2)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 2)
+	assert.JSONEq(`{"level":"info","eventID":"1","eventName":"Decrypt","eventSource":null,"awsRegion":null,"recipientAccountId":null,`+
+		`"action":"keep","rule":{"name":"drop_decrypt","action":"drop","mode":"dry_run"},"message":"dry run rule matched record"}`, lines[0])
+	assert.JSONEq(`{"level":"info","rule":{"name":"drop_decrypt","action":"drop","mode":"dry_run"},"records":1,"message":"rule matched"}`, lines[1])
+}
+
+func BenchmarkFilterRecords(b *testing.B) {
+	ruleSet, err := rules.LoadAndValidate(yamlConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// a large cloudtrail file with a mix of dropped and retained records
+	records := make([]json.RawMessage, 10000)
+	for i := range records {
+		records[i] = json.RawMessage(fmt.Sprintf(
+			`{"eventVersion":"1.08","eventName":"%s","eventSource":"%s","awsRegion":"us-east-1","recipientAccountId":"123456789012",`+
+				`"userIdentity":{"type":"AssumedRole","arn":"arn:aws:sts::123456789012:assumed-role/role-%d/session"}}`,
+			[]string{"Decrypt", "GetObject", "Encrypt"}[i%3], []string{"kms.amazonaws.com", "s3.amazonaws.com"}[i%2], i,
+		))
+	}
+
+	inct := &Cloudtrail{Records: make([]json.RawMessage, len(records))}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// filterRecords reuses the input slice so it is reset for each run
+		copy(inct.Records, records)
+
+		_, err := filterRecords(context.TODO(), inct, ruleSet, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(len(records)), "records/op")
+}
+
 func copierSuccess(ctrl *gomock.Controller, cfg flags.S3Processor) *S3Copier {
 	ssm := mocks.NewMockCache(ctrl)
 	s3svc := mocks.NewMockS3API(ctrl)
@@ -86,7 +673,7 @@ func copierSuccess(ctrl *gomock.Controller, cfg flags.S3Processor) *S3Copier {
 
 	return &S3Copier{
 		cfg:       cfg,
-		ssm:       ssm,
+		loader:    rules.NewLoader(rules.NewSSMSource(ssm, cfg.ConfigSSMParam)),
 		s3svc:     s3svc,
 		uploadsvc: uploadsvc,
 	}
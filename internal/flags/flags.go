@@ -5,7 +5,20 @@ import "github.com/alecthomas/kong"
 // S3Processor s3 processor flags
 type S3Processor struct {
 	Version                    kong.VersionFlag
-	CloudtrailOutputBucketName string `env:"CLOUDTRAIL_OUTPUT_BUCKET_NAME"`
-	ConfigSSMParam             string `env:"CONFIG_SSM_PARAM"`
-	SNSPayloadType             string `env:"SNS_PAYLOAD_TYPE"`
+	CloudtrailOutputBucketName string   `env:"CLOUDTRAIL_OUTPUT_BUCKET_NAME"`
+	ConfigSource               string   `env:"CONFIG_SOURCE"`
+	ConfigSSMParam             string   `env:"CONFIG_SSM_PARAM"`
+	ConfigSSMPath              string   `env:"CONFIG_SSM_PATH"`
+	ConfigS3Bucket             string   `env:"CONFIG_S3_BUCKET"`
+	ConfigS3Key                string   `env:"CONFIG_S3_KEY"`
+	ConfigFile                 string   `env:"CONFIG_FILE"`
+	ConfigFallback             string   `env:"CONFIG_FALLBACK" enum:"fail,pass_through,drop_all" default:"fail"`
+	ConfigSnapshotFile         string   `env:"CONFIG_SNAPSHOT_FILE"`
+	ConfigSnapshotS3Bucket     string   `env:"CONFIG_SNAPSHOT_S3_BUCKET"`
+	ConfigSnapshotS3Key        string   `env:"CONFIG_SNAPSHOT_S3_KEY"`
+	SNSPayloadType             string   `env:"SNS_PAYLOAD_TYPE"`
+	PseudonymKeySSMParam       string   `env:"PSEUDONYM_KEY_SSM_PARAM"`
+	PseudonymFields            []string `env:"PSEUDONYM_FIELDS"`
+	GeoIPCityDB                string   `env:"GEOIP_CITY_DB"`
+	GeoIPASNDB                 string   `env:"GEOIP_ASN_DB"`
 }
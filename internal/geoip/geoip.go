@@ -0,0 +1,179 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/s3url"
+)
+
+// Fields added to retained records describing the location and network of the source IP address
+const (
+	GeoField = "x_geo"
+	ASNField = "x_asn"
+)
+
+// Reader the subset of the maxminddb reader used to look up an address
+type Reader interface {
+	Lookup(ip net.IP, result interface{}) error
+}
+
+// S3API the subset of the S3 API used to read a database stored in a bucket
+type S3API interface {
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+}
+
+// City the fields read from a GeoLite2 City database, the names of the city are keyed by language
+type City struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// ASN the fields read from a GeoLite2 ASN database
+type ASN struct {
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Enricher look up the location and network of the source IP address of records, using MaxMind format
+// City and ASN databases, either of which may be omitted
+type Enricher struct {
+	city Reader
+	asn  Reader
+}
+
+// New create an enricher using the readers, a nil reader is skipped
+func New(city, asn Reader) *Enricher {
+	return &Enricher{city: city, asn: asn}
+}
+
+// Open create an enricher using the databases stored in local files or s3 objects, a location such as
+// s3://bucket/key is read from s3 and an empty location is skipped
+func Open(ctx context.Context, s3svc S3API, cityDB, asnDB string) (*Enricher, error) {
+	city, err := openReader(ctx, s3svc, cityDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open city database: %w", err)
+	}
+
+	asn, err := openReader(ctx, s3svc, asnDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open asn database: %w", err)
+	}
+
+	en := new(Enricher)
+
+	// avoid storing a nil *maxminddb.Reader in the interface
+	if city != nil {
+		en.city = city
+	}
+
+	if asn != nil {
+		en.asn = asn
+	}
+
+	return en, nil
+}
+
+func openReader(ctx context.Context, s3svc S3API, location string) (*maxminddb.Reader, error) {
+	if location == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(location, "s3://") {
+		return maxminddb.Open(location)
+	}
+
+	bucket, key, err := s3url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s3svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return maxminddb.FromBytes(data)
+}
+
+// Enrich return the fields describing the location and network of the sourceIPAddress of the record, records
+// made by AWS services have a hostname such as ec2.amazonaws.com in place of an address and are skipped. A nil
+// enricher returns no fields.
+func (en *Enricher) Enrich(evt map[string]interface{}) (map[string]interface{}, error) {
+	if en == nil {
+		return nil, nil
+	}
+
+	addr, ok := evt["sourceIPAddress"].(string)
+	if !ok {
+		return nil, nil
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, nil
+	}
+
+	fields := make(map[string]interface{})
+
+	if en.city != nil {
+		var rec City
+
+		err := en.city.Lookup(ip, &rec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %s in city database: %w", addr, err)
+		}
+
+		geo := make(map[string]interface{})
+
+		if rec.Country.ISOCode != "" {
+			geo["country"] = rec.Country.ISOCode
+		}
+
+		if name := rec.City.Names["en"]; name != "" {
+			geo["city"] = name
+		}
+
+		if len(geo) > 0 {
+			fields[GeoField] = geo
+		}
+	}
+
+	if en.asn != nil {
+		var rec ASN
+
+		err := en.asn.Lookup(ip, &rec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %s in asn database: %w", addr, err)
+		}
+
+		if rec.Organization != "" {
+			fields[ASNField] = map[string]interface{}{"org": rec.Organization}
+		}
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,144 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/mocks"
+)
+
+// fakeReader returns the record stored for each address, addresses which aren't found leave the result unchanged
+type fakeReader map[string]interface{}
+
+func (fr fakeReader) Lookup(ip net.IP, result interface{}) error {
+	if rec, ok := fr[ip.String()]; ok {
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(rec))
+	}
+
+	return nil
+}
+
+type errReader struct{}
+
+func (errReader) Lookup(net.IP, interface{}) error {
+	return errors.New("invalid database")
+}
+
+func newCity(country, city string) City {
+	var rec City
+
+	rec.Country.ISOCode = country
+	if city != "" {
+		rec.City.Names = map[string]string{"en": city, "de": city + "-de"}
+	}
+
+	return rec
+}
+
+func TestEnricher_Enrich(t *testing.T) {
+	en := New(
+		fakeReader{
+			"203.0.113.10": newCity("AU", "Sydney"),
+			"2001:db8::1":  newCity("DE", ""),
+		},
+		fakeReader{
+			"203.0.113.10": ASN{Organization: "Example Networks"},
+		},
+	)
+
+	tests := []struct {
+		name string
+		evt  map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "should add location and network",
+			evt:  map[string]interface{}{"sourceIPAddress": "203.0.113.10"},
+			want: map[string]interface{}{
+				GeoField: map[string]interface{}{"country": "AU", "city": "Sydney"},
+				ASNField: map[string]interface{}{"org": "Example Networks"},
+			},
+		},
+		{
+			name: "should omit missing values",
+			evt:  map[string]interface{}{"sourceIPAddress": "2001:db8::1"},
+			want: map[string]interface{}{
+				GeoField: map[string]interface{}{"country": "DE"},
+			},
+		},
+		{
+			name: "should skip addresses which aren't found",
+			evt:  map[string]interface{}{"sourceIPAddress": "198.51.100.1"},
+			want: map[string]interface{}{},
+		},
+		{
+			name: "should skip aws service hostnames",
+			evt:  map[string]interface{}{"sourceIPAddress": "ec2.amazonaws.com"},
+		},
+		{
+			name: "should skip records without an address",
+			evt:  map[string]interface{}{"eventName": "ConsoleLogin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := en.Enrich(tt.evt)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEnricher_EnrichErrors(t *testing.T) {
+	assert := require.New(t)
+
+	var en *Enricher
+
+	got, err := en.Enrich(map[string]interface{}{"sourceIPAddress": "203.0.113.10"})
+	assert.NoError(err)
+	assert.Nil(got)
+
+	_, err = New(nil, errReader{}).Enrich(map[string]interface{}{"sourceIPAddress": "203.0.113.10"})
+	assert.EqualError(err, "failed to look up 203.0.113.10 in asn database: invalid database")
+}
+
+func TestOpen(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s3svc := mocks.NewMockS3API(ctrl)
+	s3svc.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String("geoip-bucket"),
+		Key:    aws.String("GeoLite2-City.mmdb"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader("not a database"))}, nil)
+	s3svc.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String("geoip-bucket"),
+		Key:    aws.String("GeoLite2-ASN.mmdb"),
+	}).Return(nil, errors.New("access denied"))
+
+	en, err := Open(context.TODO(), s3svc, "", "")
+	assert.NoError(err)
+	assert.Equal(&Enricher{}, en)
+
+	_, err = Open(context.TODO(), s3svc, "s3://geoip-bucket/GeoLite2-City.mmdb", "")
+	assert.Error(err)
+	assert.True(strings.HasPrefix(err.Error(), "failed to open city database: "))
+
+	_, err = Open(context.TODO(), s3svc, "", "s3://geoip-bucket/GeoLite2-ASN.mmdb")
+	assert.EqualError(err, "failed to open asn database: access denied")
+
+	_, err = Open(context.TODO(), s3svc, "testdata/missing.mmdb", "")
+	assert.Error(err)
+}
@@ -0,0 +1,158 @@
+package pseudonym
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wolfeidau/ssmcache"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/rules"
+)
+
+// MinKeyLength the minimum length of the key in bytes
+const MinKeyLength = 32
+
+// TokenPrefix the prefix of every token, this identifies values which have been pseudonymized
+const TokenPrefix = "pn_"
+
+// DefaultFields the fields identifying the principal which made the request
+var DefaultFields = []string{
+	"userIdentity.arn",
+	"userIdentity.principalId",
+	"userIdentity.accessKeyId",
+	"sourceIPAddress",
+}
+
+var (
+	// ErrKeyTooShort returned when the key is shorter than MinKeyLength
+	ErrKeyTooShort = fmt.Errorf("pseudonym key must be at least %d bytes", MinKeyLength)
+
+	// ErrInvalidToken returned when a token wasn't created with the key
+	ErrInvalidToken = errors.New("invalid pseudonym token")
+)
+
+// Pseudonymizer replace values with tokens which are stable, so the same value always maps to the same token,
+// and can't be reversed without the key. The token is the value encrypted with AES-CTR using the first 16 bytes
+// of an HMAC-SHA256 of the value as the IV, which allows a holder of the key to re-identify the value.
+type Pseudonymizer struct {
+	macKey []byte
+	encKey []byte
+}
+
+// New create a pseudonymizer from the key, separate keys are derived for the HMAC and encryption
+func New(key []byte) (*Pseudonymizer, error) {
+	if len(key) < MinKeyLength {
+		return nil, ErrKeyTooShort
+	}
+
+	return &Pseudonymizer{
+		macKey: deriveKey(key, "mac"),
+		encKey: deriveKey(key, "enc"),
+	}, nil
+}
+
+// NewFromSSM create a pseudonymizer from the key stored in an ssm SecureString parameter
+func NewFromSSM(cache ssmcache.Cache, param string) (*Pseudonymizer, error) {
+	key, err := cache.GetKey(param, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pseudonym key from ssm: %w", err)
+	}
+
+	return New([]byte(key))
+}
+
+func deriveKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(label))
+
+	return mac.Sum(nil)
+}
+
+// Token return the token for the value
+func (p *Pseudonymizer) Token(value string) string {
+	iv := p.syntheticIV([]byte(value))
+
+	out := make([]byte, len(iv)+len(value))
+	copy(out, iv)
+	p.stream(iv).XORKeyStream(out[len(iv):], []byte(value))
+
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(out)
+}
+
+// Reidentify return the value the token was created from, returning ErrInvalidToken if it wasn't created with the key
+func (p *Pseudonymizer) Reidentify(token string) (string, error) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return "", ErrInvalidToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, TokenPrefix))
+	if err != nil || len(data) < aes.BlockSize {
+		return "", ErrInvalidToken
+	}
+
+	iv, value := data[:aes.BlockSize], make([]byte, len(data)-aes.BlockSize)
+	p.stream(iv).XORKeyStream(value, data[aes.BlockSize:])
+
+	if !hmac.Equal(iv, p.syntheticIV(value)) {
+		return "", ErrInvalidToken
+	}
+
+	return string(value), nil
+}
+
+// Apply replace the string values at the paths in the record with tokens, returning true if any were replaced
+func (p *Pseudonymizer) Apply(evt map[string]interface{}, paths []rules.Path) bool {
+	changed := false
+
+	for _, path := range paths {
+		v, ok := path.Lookup(evt)
+		if !ok {
+			continue
+		}
+
+		s, ok := v.(string)
+		if !ok || s == "" {
+			continue
+		}
+
+		changed = path.Set(evt, p.Token(s)) || changed
+	}
+
+	return changed
+}
+
+func (p *Pseudonymizer) syntheticIV(value []byte) []byte {
+	mac := hmac.New(sha256.New, p.macKey)
+	_, _ = mac.Write(value)
+
+	return mac.Sum(nil)[:aes.BlockSize]
+}
+
+func (p *Pseudonymizer) stream(iv []byte) cipher.Stream {
+	// the key is always 32 bytes as it is derived using sha256
+	block, _ := aes.NewCipher(p.encKey)
+
+	return cipher.NewCTR(block, iv)
+}
+
+// ParseFields parse the paths of the fields to pseudonymize
+func ParseFields(fields []string) ([]rules.Path, error) {
+	paths := make([]rules.Path, len(fields))
+
+	for i, fld := range fields {
+		p, err := rules.ParsePath(fld)
+		if err != nil {
+			return nil, err
+		}
+
+		paths[i] = p
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,118 @@
+package pseudonym
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/mocks"
+)
+
+const testKey = "0123456789abcdef0123456789abcdef"
+
+func TestPseudonymizer_Token(t *testing.T) {
+	assert := require.New(t)
+
+	pn, err := New([]byte(testKey))
+	assert.NoError(err)
+
+	arn := "arn:aws:sts::123456789012:assumed-role/ci-deploy/session"
+
+	token := pn.Token(arn)
+	assert.True(strings.HasPrefix(token, TokenPrefix))
+	assert.NotContains(token, "ci-deploy")
+	assert.Equal(token, pn.Token(arn))
+	assert.NotEqual(token, pn.Token("arn:aws:sts::123456789012:assumed-role/ci-release/session"))
+
+	value, err := pn.Reidentify(token)
+	assert.NoError(err)
+	assert.Equal(arn, value)
+
+	other, err := New([]byte(strings.ToUpper(testKey)))
+	assert.NoError(err)
+	assert.NotEqual(token, other.Token(arn))
+
+	_, err = other.Reidentify(token)
+	assert.True(errors.Is(err, ErrInvalidToken))
+}
+
+func TestPseudonymizer_ReidentifyInvalid(t *testing.T) {
+	pn, err := New([]byte(testKey))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "should reject missing prefix", token: "arn:aws:iam::123456789012:user/alice"},
+		{name: "should reject invalid encoding", token: TokenPrefix + "not base64!"},
+		{name: "should reject short token", token: TokenPrefix + "AAAA"},
+		{name: "should reject modified token", token: pn.Token("10.0.0.1")[:len(TokenPrefix)+22] + "AAAA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := pn.Reidentify(tt.token)
+			require.True(t, errors.Is(err, ErrInvalidToken))
+		})
+	}
+}
+
+func TestPseudonymizer_Apply(t *testing.T) {
+	assert := require.New(t)
+
+	pn, err := New([]byte(testKey))
+	assert.NoError(err)
+
+	paths, err := ParseFields(DefaultFields)
+	assert.NoError(err)
+
+	evt := map[string]interface{}{
+		"eventName": "GetObject",
+		"userIdentity": map[string]interface{}{
+			"type":        "AssumedRole",
+			"arn":         "arn:aws:sts::123456789012:assumed-role/ci-deploy/session",
+			"principalId": "AROAEXAMPLE:session",
+			"accessKeyId": "",
+		},
+		"sourceIPAddress": "10.0.0.1",
+	}
+
+	assert.True(pn.Apply(evt, paths))
+	assert.Equal(map[string]interface{}{
+		"eventName": "GetObject",
+		"userIdentity": map[string]interface{}{
+			"type":        "AssumedRole",
+			"arn":         pn.Token("arn:aws:sts::123456789012:assumed-role/ci-deploy/session"),
+			"principalId": pn.Token("AROAEXAMPLE:session"),
+			"accessKeyId": "",
+		},
+		"sourceIPAddress": pn.Token("10.0.0.1"),
+	}, evt)
+
+	assert.False(pn.Apply(map[string]interface{}{"eventName": "GetObject"}, paths))
+}
+
+func TestNewFromSSM(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cache := mocks.NewMockCache(ctrl)
+	cache.EXPECT().GetKey("/config/pseudonym_key", true).Return(testKey, nil)
+	cache.EXPECT().GetKey("/config/short_key", true).Return("short", nil)
+	cache.EXPECT().GetKey("/config/missing_key", true).Return("", errors.New("parameter not found"))
+
+	pn, err := NewFromSSM(cache, "/config/pseudonym_key")
+	assert.NoError(err)
+	assert.NotNil(pn)
+
+	_, err = NewFromSSM(cache, "/config/short_key")
+	assert.True(errors.Is(err, ErrKeyTooShort))
+
+	_, err = NewFromSSM(cache, "/config/missing_key")
+	assert.EqualError(err, "failed to read pseudonym key from ssm: parameter not found")
+}
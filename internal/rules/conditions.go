@@ -0,0 +1,190 @@
+package rules
+
+import (
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Condition condition which evaluates to true when ALL of the provided parts are true, the flat
+// list of matches, every condition in all, at least one condition in any and the negation of not.
+type Condition struct {
+	Matches []*Match     `yaml:"matches,omitempty" validate:"omitempty,dive,required"`
+	All     []*Condition `yaml:"all,omitempty" validate:"omitempty,dive,required"`
+	Any     []*Condition `yaml:"any,omitempty" validate:"omitempty,dive,required"`
+	// Not is true when the nested condition is false, apart from a match on a field missing from the record which
+	// isn't negated, so it keeps the outcome of on_missing: no_match is false, match is true and error fails
+	Not *Condition `yaml:"not,omitempty"`
+}
+
+// conditionFields the fields of a condition, at least one must be provided
+var conditionFields = []string{"matches", "all", "any", "not"}
+
+// compiledCondition condition with all nested matches and groups compiled
+type compiledCondition struct {
+	matches []*compiledMatch
+	all     []*compiledCondition
+	any     []*compiledCondition
+	not     *compiledCondition
+}
+
+func compileCondition(cd *Condition) (*compiledCondition, error) {
+	cc := new(compiledCondition)
+
+	for _, mt := range cd.Matches {
+		cm, err := compileMatch(mt)
+		if err != nil {
+			return nil, err
+		}
+
+		cc.matches = append(cc.matches, cm)
+	}
+
+	for _, sub := range cd.All {
+		sc, err := compileCondition(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		cc.all = append(cc.all, sc)
+	}
+
+	for _, sub := range cd.Any {
+		sc, err := compileCondition(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		cc.any = append(cc.any, sc)
+	}
+
+	if cd.Not != nil {
+		sc, err := compileCondition(cd.Not)
+		if err != nil {
+			return nil, err
+		}
+
+		cc.not = sc
+	}
+
+	return cc, nil
+}
+
+// outcome the result of evaluating a match or condition, missing is true when it was decided by on_missing as
+// a field is missing from the record, these outcomes are kept rather than negated by not so a record without
+// the field doesn't match both a condition and its negation
+type outcome struct {
+	match   bool
+	missing bool
+}
+
+// eval evaluate the condition for a given event
+func (cc *compiledCondition) eval(evt map[string]interface{}) (bool, error) {
+	out, err := cc.evalOutcome(evt)
+	return out.match, err
+}
+
+// evalOutcome evaluate the condition, a condition which doesn't match has the outcome of the first part which
+// didn't match, otherwise it is missing if any of the parts were
+func (cc *compiledCondition) evalOutcome(evt map[string]interface{}) (outcome, error) {
+	var missing bool
+
+	// this will run each field check in the condition and continue if ALL evaluate to true
+	for _, cm := range cc.matches {
+		out, err := cm.eval(evt)
+		if err != nil || !out.match {
+			return out, err
+		}
+
+		missing = missing || out.missing
+	}
+
+	for _, sub := range cc.all {
+		out, err := sub.evalOutcome(evt)
+		if err != nil || !out.match {
+			return out, err
+		}
+
+		missing = missing || out.missing
+	}
+
+	if len(cc.any) > 0 {
+		out, err := cc.evalAny(evt)
+		if err != nil || !out.match {
+			return out, err
+		}
+
+		missing = missing || out.missing
+	}
+
+	if cc.not != nil {
+		out, err := cc.not.evalOutcome(evt)
+		if err != nil {
+			return outcome{}, err
+		}
+
+		// an outcome decided by a missing field is kept, so on_missing applies within not
+		if !out.missing {
+			out.match = !out.match
+		}
+
+		if !out.match {
+			return out, nil
+		}
+
+		missing = missing || out.missing
+	}
+
+	return outcome{match: true, missing: missing}, nil
+}
+
+// evalAny return the outcome of the first condition which matches, otherwise no match which is missing if any
+// of the conditions were
+func (cc *compiledCondition) evalAny(evt map[string]interface{}) (outcome, error) {
+	var missing bool
+
+	for _, sub := range cc.any {
+		out, err := sub.evalOutcome(evt)
+		if err != nil {
+			return outcome{}, err
+		}
+
+		if out.match {
+			return out, nil
+		}
+
+		missing = missing || out.missing
+	}
+
+	return outcome{missing: missing}, nil
+}
+
+// IsEmpty returns true if the condition has nothing to evaluate
+func (cd *Condition) IsEmpty() bool {
+	return len(cd.Matches) == 0 && len(cd.All) == 0 && len(cd.Any) == 0 && cd.Not == nil
+}
+
+// ValidateCondition implements validator.StructLevelFunc, ensuring every nested condition has something to evaluate
+func ValidateCondition(sl validator.StructLevel) {
+	cd, ok := sl.Current().Interface().(Condition)
+	if !ok {
+		return
+	}
+
+	// null entries are reported by the required tag
+	for _, sub := range cd.All {
+		if sub != nil && sub.IsEmpty() {
+			sl.ReportError(cd.All, "all", "All", "required_without_all", strings.Join(conditionFields, " "))
+		}
+	}
+
+	for _, sub := range cd.Any {
+		if sub != nil && sub.IsEmpty() {
+			sl.ReportError(cd.Any, "any", "Any", "required_without_all", strings.Join(conditionFields, " "))
+		}
+	}
+
+	if cd.Not != nil && cd.Not.IsEmpty() {
+		sl.ReportError(cd.Not, "not", "Not", "required_without_all", strings.Join(conditionFields, " "))
+	}
+}
@@ -0,0 +1,276 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var yamlConditionConfig = `
+---
+version: 2
+rules:
+  - name: kms_noise
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    not:
+      any:
+        - matches:
+          - field_name: userIdentity.type
+            op: equals
+            value: AWSService
+        - matches:
+          - field_name: eventName
+            op: in
+            values: [Decrypt, GenerateDataKey]
+`
+
+func TestCondition_Eval(t *testing.T) {
+	ctr, err := Load(yamlConditionConfig)
+	require.NoError(t, err)
+	require.NoError(t, ctr.Validate())
+
+	tests := []struct {
+		name string
+		evt  map[string]interface{}
+		want bool
+	}{
+		{
+			name: "should match kms event from a user",
+			evt: map[string]interface{}{
+				"eventSource":  "kms.amazonaws.com",
+				"eventName":    "CreateKey",
+				"userIdentity": map[string]interface{}{"type": "IAMUser"},
+			},
+			want: true,
+		},
+		{
+			name: "should not match kms event from a service",
+			evt: map[string]interface{}{
+				"eventSource":  "kms.amazonaws.com",
+				"eventName":    "CreateKey",
+				"userIdentity": map[string]interface{}{"type": "AWSService"},
+			},
+		},
+		{
+			name: "should not match excluded kms event names",
+			evt: map[string]interface{}{
+				"eventSource":  "kms.amazonaws.com",
+				"eventName":    "Decrypt",
+				"userIdentity": map[string]interface{}{"type": "IAMUser"},
+			},
+		},
+		{
+			name: "should not match other event sources",
+			evt: map[string]interface{}{
+				"eventSource":  "s3.amazonaws.com",
+				"eventName":    "CreateBucket",
+				"userIdentity": map[string]interface{}{"type": "IAMUser"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := evalCondition(&ctr.Rules[0].Condition, tt.evt)
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestCondition_EvalAll(t *testing.T) {
+	assert := require.New(t)
+
+	cd := &Condition{All: []*Condition{
+		{Matches: []*Match{{FieldName: "eventSource", Op: OpPrefix, Value: "kms."}}},
+		{Matches: []*Match{{FieldName: "eventName", Op: OpEquals, Value: "Decrypt"}}},
+	}}
+
+	got, err := evalCondition(cd, map[string]interface{}{"eventSource": "kms.amazonaws.com", "eventName": "Decrypt"})
+	assert.NoError(err)
+	assert.True(got)
+
+	got, err = evalCondition(cd, map[string]interface{}{"eventSource": "kms.amazonaws.com", "eventName": "Encrypt"})
+	assert.NoError(err)
+	assert.False(got)
+}
+
+func TestCondition_EvalNotMissing(t *testing.T) {
+	isService := func(onMissing string) *Match {
+		return &Match{FieldName: "userIdentity.type", Op: OpEquals, Value: "AWSService", OnMissing: onMissing}
+	}
+
+	tests := []struct {
+		name string
+		cd   *Condition
+		evt  map[string]interface{}
+		want bool
+	}{
+		{
+			name: "should negate a field which is present",
+			cd:   &Condition{Not: &Condition{Matches: []*Match{isService("")}}},
+			evt:  map[string]interface{}{"userIdentity": map[string]interface{}{"type": "IAMUser"}},
+			want: true,
+		},
+		{
+			name: "should not match a missing field under not",
+			cd:   &Condition{Not: &Condition{Matches: []*Match{isService("")}}},
+			evt:  map[string]interface{}{"eventName": "ConsoleLogin"},
+		},
+		{
+			name: "should apply on_missing match under not",
+			cd:   &Condition{Not: &Condition{Matches: []*Match{isService(OnMissingMatch)}}},
+			evt:  map[string]interface{}{"eventName": "ConsoleLogin"},
+			want: true,
+		},
+		{
+			name: "should keep a missing field missing through nested not and any",
+			cd: &Condition{Not: &Condition{Any: []*Condition{
+				{Matches: []*Match{{FieldName: "eventName", Op: OpEquals, Value: "Decrypt"}}},
+				{Not: &Condition{Matches: []*Match{isService("")}}},
+			}}},
+			evt: map[string]interface{}{"eventName": "ConsoleLogin"},
+		},
+		{
+			name: "should negate not_exists as it checks presence",
+			cd:   &Condition{Not: &Condition{Matches: []*Match{{FieldName: "userIdentity.type", Op: OpNotExists}}}},
+			evt:  map[string]interface{}{"eventName": "ConsoleLogin"},
+		},
+		{
+			name: "should negate exists as it checks presence",
+			cd:   &Condition{Not: &Condition{Matches: []*Match{{FieldName: "userIdentity.type", Op: OpExists}}}},
+			evt:  map[string]interface{}{"eventName": "ConsoleLogin"},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalCondition(tt.cd, tt.evt)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func evalCondition(cd *Condition, evt map[string]interface{}) (bool, error) {
+	cc, err := compileCondition(cd)
+	if err != nil {
+		return false, err
+	}
+
+	return cc.eval(evt)
+}
+
+func TestValidateCondition(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     string
+		wantErr bool
+	}{
+		{
+			name: "should accept nested groups",
+			cfg:  yamlConditionConfig,
+		},
+		{
+			name:    "should reject rule without a condition",
+			cfg:     "version: 2\nrules:\n  - name: empty\n",
+			wantErr: true,
+		},
+		{
+			name:    "should reject empty nested condition",
+			cfg:     "version: 2\nrules:\n  - name: empty_any\n    any:\n      - {}\n",
+			wantErr: true,
+		},
+		{
+			name:    "should reject invalid nested match",
+			cfg:     "version: 2\nrules:\n  - name: bad_not\n    not:\n      matches:\n        - {field_name: eventName, op: equals}\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ctr, err := Load(tt.cfg)
+			assert.NoError(err)
+
+			err = ctr.Validate()
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestValidateCondition_NullEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		cond    string
+		wantErr string
+	}{
+		{name: "should reject null match", cond: "matches: [~]", wantErr: "rules[0].matches[0]"},
+		{name: "should reject null all", cond: "all: [~]", wantErr: "rules[0].all[0]"},
+		{name: "should reject null any", cond: "any: [~]", wantErr: "rules[0].any[0]"},
+		{name: "should reject null nested match", cond: "not: {matches: [~]}", wantErr: "rules[0].not.matches[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadAndValidate("version: 2\nrules:\n  - name: null_entry\n    " + tt.cond + "\n")
+			require.EqualError(t, err, `rules validation failed: line 4: `+tt.wantErr+` in rule "null_entry": is required`)
+		})
+	}
+}
+
+func TestRuleSet_EvaluateNotOnMissing(t *testing.T) {
+	newRuleSet := func(onMissing string) *RuleSet {
+		rs, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: not_service
+    not:
+      matches:
+      - field_name: userIdentity.type
+        op: equals
+        value: AWSService
+        on_missing: ` + onMissing + `
+`)
+		require.NoError(t, err)
+
+		return rs
+	}
+
+	missing := map[string]interface{}{"eventName": "ConsoleLogin"}
+	service := map[string]interface{}{"userIdentity": map[string]interface{}{"type": "AWSService"}}
+
+	tests := []struct {
+		name      string
+		onMissing string
+		evt       map[string]interface{}
+		want      string
+		wantErr   error
+	}{
+		{name: "should keep records without the field by default", onMissing: OnMissingNoMatch, evt: missing, want: ActionKeep},
+		{name: "should drop records without the field when on_missing is match", onMissing: OnMissingMatch, evt: missing, want: ActionDrop},
+		{name: "should fail records without the field when on_missing is error", onMissing: OnMissingError, evt: missing, wantErr: ErrFieldMissing},
+		{name: "should negate a field which is present", onMissing: OnMissingMatch, evt: service, want: ActionKeep},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec, err := newRuleSet(tt.onMissing).Evaluate(tt.evt)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, dec.Action)
+		})
+	}
+}
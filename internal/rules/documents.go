@@ -0,0 +1,322 @@
+package rules
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document a configuration document along with the source it was read from
+type Document struct {
+	Source  string
+	Content string
+	// List the name of the list when the document contains the values of a list stored in ssm or s3
+	List string
+	// Table the name of the table when the document contains the rows of a table stored in ssm or s3
+	Table string
+}
+
+// origin the document a pattern or list was loaded from
+type origin struct {
+	source string
+	node   *yaml.Node
+}
+
+// Merge load each of the documents and merge them into a single configuration, rules and transforms are
+// kept in the order of the documents and record the source they were loaded from. Rule, pattern and list
+// names must be unique across all documents, and only one default_action, projection and enrichment may be
+// configured. The values of lists and rows of tables stored in ssm or s3 are loaded from the documents containing them.
+func Merge(docs []*Document) (*Configuration, error) {
+	merged := &Configuration{
+		Version:  CurrentVersion,
+		Patterns: make(map[string]string),
+		Lists:    make(map[string]*List),
+		origins:  make(map[string]*origin),
+	}
+
+	var (
+		errs      ValidationErrors
+		listDocs  []*Document
+		tableDocs []*Document
+	)
+
+	names := make(map[string]*Rule)
+
+	for _, doc := range docs {
+		if doc.List != "" {
+			listDocs = append(listDocs, doc)
+			continue
+		}
+
+		if doc.Table != "" {
+			tableDocs = append(tableDocs, doc)
+			continue
+		}
+
+		cfg, err := Load(doc.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", doc.Source, err)
+		}
+
+		if merged.node == nil {
+			merged.node, merged.source = cfg.node, doc.Source
+		}
+
+		errs = append(errs, merged.mergeDefaultAction(doc.Source, cfg)...)
+		errs = append(errs, merged.mergeRules(doc.Source, cfg, names)...)
+		errs = append(errs, merged.mergeSingletons(doc.Source, cfg)...)
+		errs = append(errs, merged.mergeTransforms(doc.Source, cfg)...)
+		errs = append(errs, merged.mergeDefinitions(doc.Source, cfg)...)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	err := merged.loadLists(listDocs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = merged.loadTables(tableDocs)
+	if err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+// mergeDefaultAction set the default action if configured in the document, reporting a conflict with the default
+// action configured in an earlier document
+func (cr *Configuration) mergeDefaultAction(source string, cfg *Configuration) ValidationErrors {
+	// version 1 documents are upgraded with an explicit default, so check it was set in the document
+	dn := mappingValue(documentRoot(cfg.node), "default_action")
+	if dn == nil {
+		return nil
+	}
+
+	if prev, ok := cr.origins["default_action"]; ok {
+		if cr.DefaultAction == cfg.DefaultAction {
+			return nil
+		}
+
+		return ValidationErrors{{
+			Source:  source,
+			Path:    "default_action",
+			Line:    dn.Line,
+			Message: fmt.Sprintf("%q conflicts with %q configured in %s", cfg.DefaultAction, cr.DefaultAction, prev.source),
+		}}
+	}
+
+	cr.DefaultAction = cfg.DefaultAction
+	cr.node, cr.source = cfg.node, source
+	cr.origins["default_action"] = &origin{source: source, node: cfg.node}
+
+	return nil
+}
+
+// mergeRules add the rules in the document, recording where each was loaded from and reporting null rules and
+// names already used by an earlier rule
+func (cr *Configuration) mergeRules(source string, cfg *Configuration, names map[string]*Rule) ValidationErrors {
+	var errs ValidationErrors
+
+	for i, rule := range cfg.Rules {
+		seg := fmt.Sprintf("rules[%d]", i)
+
+		if rule == nil {
+			errs = append(errs, &ValidationError{
+				Source:  source,
+				Path:    seg,
+				Line:    locateLine(cfg.node, []string{seg}),
+				Message: "is required",
+			})
+
+			continue
+		}
+
+		rule.Source, rule.node, rule.index = source, cfg.node, i
+
+		if prev, ok := names[rule.Name]; ok && rule.Name != "" {
+			errs = append(errs, &ValidationError{
+				Source:  source,
+				Path:    seg + ".name",
+				Rule:    rule.Name,
+				Line:    locateLine(cfg.node, []string{seg, "name"}),
+				Message: fmt.Sprintf("duplicate rule name, also defined in %s", prev.Source),
+			})
+		}
+
+		names[rule.Name] = rule
+		cr.Rules = append(cr.Rules, rule)
+	}
+
+	return errs
+}
+
+// mergeTransforms add the transforms in the document, recording where each was loaded from and reporting null
+// transforms
+func (cr *Configuration) mergeTransforms(source string, cfg *Configuration) ValidationErrors {
+	var errs ValidationErrors
+
+	for i, tr := range cfg.Transforms {
+		seg := fmt.Sprintf("transforms[%d]", i)
+
+		if tr == nil {
+			errs = append(errs, &ValidationError{
+				Source:  source,
+				Path:    seg,
+				Line:    locateLine(cfg.node, []string{seg}),
+				Message: "is required",
+			})
+
+			continue
+		}
+
+		tr.Source, tr.node, tr.index = source, cfg.node, i
+		cr.Transforms = append(cr.Transforms, tr)
+	}
+
+	return errs
+}
+
+// mergeSingletons set the projection and enrichment if configured in the document, these may only be configured
+// in one document
+func (cr *Configuration) mergeSingletons(source string, cfg *Configuration) ValidationErrors {
+	var errs ValidationErrors
+
+	single := func(name string, configured bool, set func()) {
+		if !configured {
+			return
+		}
+
+		if prev, ok := cr.origins[name]; ok {
+			errs = append(errs, &ValidationError{
+				Source:  source,
+				Path:    name,
+				Line:    locateLine(cfg.node, []string{name}),
+				Message: fmt.Sprintf("already configured in %s", prev.source),
+			})
+
+			return
+		}
+
+		set()
+		cr.origins[name] = &origin{source: source, node: cfg.node}
+	}
+
+	single("projection", cfg.Projection != nil, func() { cr.Projection = cfg.Projection })
+	single("enrichment", cfg.Enrichment != nil, func() { cr.Enrichment = cfg.Enrichment })
+
+	return errs
+}
+
+// loadLists set the values of the lists stored in ssm or s3 from the documents containing them
+func (cr *Configuration) loadLists(docs []*Document) error {
+	for _, doc := range docs {
+		list, ok := cr.Lists[doc.List]
+		if !ok || !list.isRemote() {
+			return fmt.Errorf("%s: list %s is not stored in ssm or s3", doc.Source, doc.List)
+		}
+
+		values, err := decodeList(doc.Content)
+		if err != nil {
+			return fmt.Errorf("%s: %w", doc.Source, err)
+		}
+
+		list.loaded = values
+	}
+
+	return nil
+}
+
+// loadTables set the rows of the tables stored in ssm or s3 from the documents containing them
+func (cr *Configuration) loadTables(docs []*Document) error {
+	for _, doc := range docs {
+		table, ok := cr.tables()[doc.Table]
+		if !ok || !table.isRemote() {
+			return fmt.Errorf("%s: table %s is not stored in ssm or s3", doc.Source, doc.Table)
+		}
+
+		rows, err := decodeTable(doc.Content, table.Format)
+		if err != nil {
+			return fmt.Errorf("%s: %w", doc.Source, err)
+		}
+
+		table.loaded = rows
+	}
+
+	return nil
+}
+
+// mergeDefinitions add the patterns and lists defined in the document, reporting names which are already defined
+func (cr *Configuration) mergeDefinitions(source string, cfg *Configuration) ValidationErrors {
+	var errs ValidationErrors
+
+	define := func(kind, name string) bool {
+		seg := fmt.Sprintf("%ss[%s]", kind, name)
+
+		if prev, ok := cr.origins[seg]; ok {
+			errs = append(errs, &ValidationError{
+				Source:  source,
+				Path:    seg,
+				Line:    locateLine(cfg.node, []string{seg}),
+				Message: fmt.Sprintf("duplicate %s name, also defined in %s", kind, prev.source),
+			})
+
+			return false
+		}
+
+		cr.origins[seg] = &origin{source: source, node: cfg.node}
+
+		return true
+	}
+
+	for _, name := range cfg.patternNames() {
+		if define("pattern", name) {
+			cr.Patterns[name] = cfg.Patterns[name]
+		}
+	}
+
+	for _, name := range cfg.listNames() {
+		if define("list", name) {
+			cr.Lists[name] = cfg.Lists[name]
+		}
+	}
+
+	return errs
+}
+
+// MergeAndValidate merge the documents into a single configuration, validate it, compile it into a rule set
+// and run the tests embedded in the rules
+func MergeAndValidate(docs []*Document) (*RuleSet, error) {
+	rulesCfg, err := Merge(docs)
+	if err != nil {
+		return nil, fmt.Errorf("merge rules configuration failed: %w", err)
+	}
+
+	return validateAndCompile(rulesCfg)
+}
+
+func sameDocuments(a, b []*Document) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if *a[i] != *b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func documentSources(docs []*Document) []string {
+	sources := make([]string, len(docs))
+
+	for i, doc := range docs {
+		sources[i] = doc.Source
+	}
+
+	return sources
+}
@@ -0,0 +1,107 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	yamlBaselineDoc = `
+version: 2
+default_action: keep
+rules:
+  - name: check_kms
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+`
+	yamlTeamDoc = `
+rules:
+  - name: check_s3
+    matches:
+    - field_name: eventSource
+      regex: "s3.*"
+`
+)
+
+func TestMerge(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := Merge([]*Document{
+		{Source: "/rules/00-baseline", Content: yamlBaselineDoc},
+		{Source: "/rules/team-a", Content: yamlTeamDoc},
+	})
+	assert.NoError(err)
+	assert.NoError(cfg.Validate())
+	assert.Equal(ActionKeep, cfg.DefaultAction)
+	assert.Len(cfg.Rules, 2)
+	assert.Equal("/rules/00-baseline", cfg.Rules[0].Source)
+	assert.Equal("/rules/team-a", cfg.Rules[1].Source)
+}
+
+func TestMerge_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		docs []*Document
+		want string
+	}{
+		{
+			name: "should reject duplicate rule names",
+			docs: []*Document{
+				{Source: "/rules/a", Content: yamlBaselineDoc},
+				{Source: "/rules/b", Content: "version: 2\nrules:\n  - name: check_kms\n    when: has(record.eventName)\n"},
+			},
+			want: `/rules/b: line 3: rules[0].name in rule "check_kms": duplicate rule name, also defined in /rules/a`,
+		},
+		{
+			name: "should reject conflicting default actions",
+			docs: []*Document{
+				{Source: "/rules/a", Content: yamlBaselineDoc},
+				{Source: "/rules/b", Content: "version: 2\ndefault_action: drop\nrules: []\n"},
+			},
+			want: `/rules/b: line 2: default_action: "drop" conflicts with "keep" configured in /rules/a`,
+		},
+		{
+			name: "should reject null rules",
+			docs: []*Document{
+				{Source: "/rules/a", Content: yamlBaselineDoc},
+				{Source: "/rules/b", Content: "version: 2\nrules:\n  - ~\n"},
+			},
+			want: `/rules/b: line 3: rules[0]: is required`,
+		},
+		{
+			name: "should report the source of invalid documents",
+			docs: []*Document{
+				{Source: "/rules/a", Content: "rules: [}"},
+			},
+			want: "/rules/a: yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Merge(tt.docs)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestMergeAndValidate_LocatesErrors(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := MergeAndValidate([]*Document{
+		{Source: "/rules/a", Content: yamlBaselineDoc},
+		{Source: "/rules/b", Content: "version: 2\nrules:\n  - name: bad_regex\n    matches:\n    - field_name: eventName\n      regex: \"(Decrypt\"\n"},
+	})
+	assert.Error(err)
+
+	var verrs ValidationErrors
+	assert.True(errors.As(err, &verrs))
+	assert.Len(verrs, 1)
+	assert.Equal("/rules/b", verrs[0].Source)
+	assert.Equal("rules[0].matches[0].regex", verrs[0].Path)
+	assert.Equal(6, verrs[0].Line)
+}
@@ -0,0 +1,324 @@
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/s3url"
+)
+
+// ValidationError a single problem found when validating the configuration
+type ValidationError struct {
+	// Source where the document containing the field was loaded from, when merging multiple documents
+	Source string
+	// Path the path to the field in the configuration, for example rules[0].matches[1].regex
+	Path string
+	// Rule the name of the rule containing the field, if any
+	Rule string
+	// Line the line in the YAML document, zero if unknown
+	Line int
+	// Message a human readable explanation of the problem
+	Message string
+}
+
+func (ve *ValidationError) Error() string {
+	var sb strings.Builder
+
+	if ve.Source != "" {
+		sb.WriteString(ve.Source + ": ")
+	}
+
+	if ve.Line > 0 {
+		sb.WriteString("line " + strconv.Itoa(ve.Line) + ": ")
+	}
+
+	sb.WriteString(ve.Path)
+
+	if ve.Rule != "" {
+		sb.WriteString(" in rule " + strconv.Quote(ve.Rule))
+	}
+
+	sb.WriteString(": " + ve.Message)
+
+	return sb.String()
+}
+
+// ValidationErrors all the problems found when validating the configuration
+type ValidationErrors []*ValidationError
+
+func (ve ValidationErrors) Error() string {
+	msgs := make([]string, len(ve))
+
+	for i, e := range ve {
+		msgs[i] = e.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+func (cr *Configuration) translateErrors(verrs validator.ValidationErrors) ValidationErrors {
+	errs := make(ValidationErrors, len(verrs))
+
+	for i, fe := range verrs {
+		segs := namespaceSegments(fe.Namespace())
+		source, path, line := cr.locate(segs)
+
+		errs[i] = &ValidationError{
+			Source:  source,
+			Path:    path,
+			Rule:    cr.ruleName(segs),
+			Line:    line,
+			Message: explain(fe),
+		}
+	}
+
+	return errs
+}
+
+// locate return the source, path and line of the field at the path segments, merged rules are located
+// in the document they were loaded from
+func (cr *Configuration) locate(segs []string) (string, string, int) {
+	source, node := cr.source, cr.node
+
+	if rule := cr.rule(segs); rule != nil && rule.node != nil {
+		source, node = rule.Source, rule.node
+		segs = append([]string{fmt.Sprintf("rules[%d]", rule.index)}, segs[1:]...)
+	}
+
+	if tr := cr.transform(segs); tr != nil && tr.node != nil {
+		source, node = tr.Source, tr.node
+		segs = append([]string{fmt.Sprintf("transforms[%d]", tr.index)}, segs[1:]...)
+	}
+
+	if len(segs) > 0 && cr.origins[segs[0]] != nil {
+		source, node = cr.origins[segs[0]].source, cr.origins[segs[0]].node
+	}
+
+	return source, strings.Join(segs, "."), locateLine(node, segs)
+}
+
+// namespaceSegments convert the validator namespace, such as Configuration.rules[0].Condition.matches[1].regex,
+// into the path segments in the YAML document, dropping the top level struct and inline fields
+func namespaceSegments(ns string) []string {
+	parts := splitNamespace(ns)
+
+	segs := make([]string, 0, len(parts))
+
+	for _, part := range parts[1:] {
+		if part == "Condition" || part == "Location" {
+			continue
+		}
+
+		segs = append(segs, part)
+	}
+
+	return segs
+}
+
+// splitNamespace split the namespace on dots outside of brackets, as the names of map entries such as
+// event sources may contain dots
+func splitNamespace(ns string) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+
+	for i, c := range ns {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				parts = append(parts, ns[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, ns[start:])
+}
+
+// ruleName return the name of the rule the path refers to, if any
+func (cr *Configuration) ruleName(segs []string) string {
+	rule := cr.rule(segs)
+	if rule == nil {
+		return ""
+	}
+
+	return rule.Name
+}
+
+// rule return the rule the path refers to, if any
+func (cr *Configuration) rule(segs []string) *Rule {
+	if len(segs) == 0 {
+		return nil
+	}
+
+	key, idx := splitSegment(segs[0])
+	if key != "rules" || idx < 0 || idx >= len(cr.Rules) {
+		return nil
+	}
+
+	return cr.Rules[idx]
+}
+
+// locateLine walk the YAML document following the path segments, returning the line of the deepest node found
+func locateLine(node *yaml.Node, segs []string) int {
+	if node == nil {
+		return 0
+	}
+
+	node = documentRoot(node)
+
+	line := node.Line
+
+	for _, seg := range segs {
+		key, idx := splitSegment(seg)
+		key, name := splitMapSegment(key)
+
+		node = mappingValue(node, key)
+		if node == nil {
+			return line
+		}
+
+		line = node.Line
+
+		if name != "" {
+			node = mappingValue(node, name)
+			if node == nil {
+				return line
+			}
+
+			line = node.Line
+		}
+
+		if idx < 0 {
+			continue
+		}
+
+		if node.Kind != yaml.SequenceNode || idx >= len(node.Content) {
+			return line
+		}
+
+		node = node.Content[idx]
+		line = node.Line
+	}
+
+	return line
+}
+
+// documentRoot return the top level node of the YAML document
+func documentRoot(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+
+	return node
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// splitSegment split a segment such as matches[1] into the key and index, the index is -1 if not present
+func splitSegment(seg string) (string, int) {
+	start := strings.LastIndexByte(seg, '[')
+	if start == -1 || !strings.HasSuffix(seg, "]") {
+		return seg, -1
+	}
+
+	idx, err := strconv.Atoi(seg[start+1 : len(seg)-1])
+	if err != nil {
+		return seg, -1
+	}
+
+	return seg[:start], idx
+}
+
+// splitMapSegment split a segment such as patterns[ci_roles] into the key and the name of the entry in the map,
+// the name is empty if not present
+func splitMapSegment(seg string) (string, string) {
+	start := strings.IndexByte(seg, '[')
+	if start == -1 || !strings.HasSuffix(seg, "]") {
+		return seg, ""
+	}
+
+	return seg[:start], seg[start+1 : len(seg)-1]
+}
+
+// explain return a human readable explanation for the validation failure
+func explain(fe validator.FieldError) string {
+	value := fmt.Sprint(fe.Value())
+
+	switch fe.Tag() {
+	case "required":
+		if fe.Param() != "" {
+			return fmt.Sprintf("is required for the %s operator", fe.Param())
+		}
+		return "is required"
+	case "required_if":
+		return fmt.Sprintf("is required when %s", strings.Replace(fe.Param(), " ", " is ", 1))
+	case "excluded_unless":
+		return fmt.Sprintf("can only be used when %s", strings.Replace(fe.Param(), " ", " is ", 1))
+	case "min":
+		if fe.Kind() == reflect.Slice && fe.Param() == "1" {
+			return "must not be empty"
+		}
+		return fmt.Sprintf("%s must be at least %s", value, fe.Param())
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", value, fe.Param())
+	case "lte":
+		return fmt.Sprintf("%s must be at most %s", value, fe.Param())
+	case "required_without_all":
+		return fmt.Sprintf("at least one of %s is required", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "oneof":
+		return fmt.Sprintf("%q is not valid, must be one of %s", value, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "operator":
+		return fmt.Sprintf("%q is not valid, must be one of %s", value, strings.Join(operators, ", "))
+	case "is-regex":
+		_, err := regexp.Compile(value)
+		return fmt.Sprintf("is not a valid regular expression: %v", err)
+	case "field-path":
+		_, err := ParsePath(value)
+		return err.Error()
+	case "cel":
+		_, err := CompileExpression(value)
+		return fmt.Sprintf("is not a valid expression: %v", err)
+	case "numeric":
+		return fmt.Sprintf("%q is not a number, which is required for the %s operator", value, fe.Param())
+	case "cidr":
+		return fmt.Sprintf("%q is not a valid CIDR range", fe.Param())
+	case "excluded_with":
+		return fmt.Sprintf("can't be used with %s", fe.Param())
+	case "defined":
+		return fmt.Sprintf("%q is not defined in %s", value, fe.Param())
+	case "unique":
+		return fmt.Sprintf("duplicate rule name, also used by %s", fe.Param())
+	case "datetime":
+		return fmt.Sprintf("%q is not a valid date, expected YYYY-MM-DD", value)
+	case "s3-url":
+		_, _, err := s3url.Parse(value)
+		return err.Error()
+	default:
+		return fmt.Sprintf("failed %s validation", fe.Tag())
+	}
+}
@@ -0,0 +1,76 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadStrict(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := Load(`
+rules:
+  - name: check_kms
+    matches:
+    - field_name: eventSource
+      regx: "kms.*"
+`)
+	assert.Error(err)
+	assert.Contains(err.Error(), "line 6: field regx not found")
+}
+
+func TestLoadEmpty(t *testing.T) {
+	assert := require.New(t)
+
+	ctr, err := Load("")
+	assert.NoError(err)
+	assert.EqualError(ctr.Validate(), "rules: is required")
+}
+
+func TestValidationErrors(t *testing.T) {
+	assert := require.New(t)
+
+	ctr, err := Load(`
+version: 2
+default_action: allow
+rules:
+  - name: check_kms
+    action: keep
+    matches:
+    - field_name: eventSource
+      op: equals
+    - field_name: eventName
+      regex: "(Decrypt"
+  - name: ip_ranges
+    any:
+      - matches:
+        - field_name: sourceIPAddress
+          op: cidr
+          value: 10.0.0.0/33
+      - {}
+  - matches:
+    - field_name: eventName
+      op: gt
+      value: ten
+`)
+	assert.NoError(err)
+
+	err = ctr.Validate()
+	assert.Error(err)
+
+	var verrs ValidationErrors
+	assert.True(errors.As(err, &verrs))
+
+	assert.Equal(ValidationErrors{
+		{Path: "default_action", Line: 3, Message: `"allow" is not valid, must be one of drop, keep`},
+		{Path: "rules[0].matches[0].value", Rule: "check_kms", Line: 8, Message: "is required for the equals operator"},
+		{Path: "rules[0].matches[1].regex", Rule: "check_kms", Line: 11,
+			Message: "is not a valid regular expression: error parsing regexp: missing closing ): `(Decrypt`"},
+		{Path: "rules[1].any[0].matches[0].value", Rule: "ip_ranges", Line: 17, Message: `"10.0.0.0/33" is not a valid CIDR range`},
+		{Path: "rules[1].any", Rule: "ip_ranges", Line: 14, Message: "at least one of matches, all, any, not is required"},
+		{Path: "rules[2].name", Line: 19, Message: "is required"},
+		{Path: "rules[2].matches[0].value", Line: 22, Message: `"ten" is not a number, which is required for the gt operator`},
+	}, verrs)
+}
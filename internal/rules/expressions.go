@@ -0,0 +1,138 @@
+package rules
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/segmentio/encoding/json"
+)
+
+// recordVar name of the variable which holds the cloudtrail record in expressions
+const recordVar = "record"
+
+var (
+	celEnvOnce sync.Once
+	celEnv     *cel.Env
+	celEnvErr  error
+)
+
+// expressionEnv return the shared CEL environment used to check and compile expressions, the record
+// is declared as a map of string to dyn as the structure of cloudtrail records varies by service
+func expressionEnv() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		reg, err := types.NewRegistry()
+		if err != nil {
+			celEnvErr = err
+			return
+		}
+
+		celEnv, celEnvErr = cel.NewEnv(
+			cel.CustomTypeProvider(reg),
+			cel.CustomTypeAdapter(recordAdapter{TypeAdapter: reg}),
+			cel.Declarations(decls.NewVar(recordVar, decls.NewMapType(decls.String, decls.Dyn))),
+			// numbers in records are ints or doubles depending on how they are written, so allow these to be compared
+			cel.CrossTypeNumericComparisons(true),
+		)
+	})
+
+	return celEnv, celEnvErr
+}
+
+// CompileExpression parse, type check and compile the expression into a program, expressions must return a bool
+func CompileExpression(expr string) (cel.Program, error) {
+	env, err := expressionEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, iss := env.Compile(expr)
+	if iss != nil && iss.Err() != nil {
+		return nil, iss.Err()
+	}
+
+	switch resultType := cel.FormatType(ast.ResultType()); resultType {
+	case "bool", "dyn":
+	default:
+		return nil, fmt.Errorf("expression must return a bool, got %s", resultType)
+	}
+
+	return env.Program(ast)
+}
+
+// evalExpression evaluate the expression against the record, evaluation errors such as a reference to a
+// field which is missing from the record result in no match and are returned so they can be reported
+func evalExpression(prg cel.Program, evt map[string]interface{}) (bool, error) {
+	out, _, err := prg.Eval(map[string]interface{}{recordVar: evt})
+	if err != nil {
+		return false, &expressionError{err: err}
+	}
+
+	b, ok := out.Value().(bool)
+
+	return ok && b, nil
+}
+
+// expressionError an error evaluating an expression against a record, the rule or transform doesn't match
+type expressionError struct {
+	err error
+}
+
+func (ee *expressionError) Error() string {
+	return fmt.Sprintf("when expression failed: %s", ee.err)
+}
+
+func (ee *expressionError) Unwrap() error {
+	return ee.err
+}
+
+// ExpressionErrors the errors from evaluating the when expressions of rules or transforms against a record,
+// keyed by name, these are treated as no match
+type ExpressionErrors map[string]error
+
+func (ee *ExpressionErrors) add(name string, err error) {
+	if *ee == nil {
+		*ee = make(ExpressionErrors)
+	}
+
+	(*ee)[name] = err
+}
+
+// ValidateIsExpression implements validator.Func
+func ValidateIsExpression(fl validator.FieldLevel) bool {
+	_, err := CompileExpression(fl.Field().String())
+	return err == nil
+}
+
+// recordAdapter extends the default adapter to support json.Number, which is used when decoding
+// records, this is applied to nested maps and lists as they are accessed
+type recordAdapter struct {
+	ref.TypeAdapter
+}
+
+// NativeToValue implements ref.TypeAdapter
+func (ra recordAdapter) NativeToValue(value interface{}) ref.Val {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return types.Int(i)
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return types.NewErr("invalid number: %s", v)
+		}
+
+		return types.Double(f)
+	case map[string]interface{}:
+		return types.NewStringInterfaceMap(ra, v)
+	case []interface{}:
+		return types.NewDynamicList(ra, v)
+	}
+
+	return ra.TypeAdapter.NativeToValue(value)
+}
@@ -0,0 +1,197 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileExpression(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "should compile cross field comparison", expr: `record.userIdentity.accountId != record.recipientAccountId && record.readOnly == false`},
+		{name: "should compile has macro", expr: `has(record.errorCode)`},
+		{name: "should reject syntax error", expr: `record.eventName ==`, wantErr: true},
+		{name: "should reject unknown variable", expr: `event.eventName == "Decrypt"`, wantErr: true},
+		{name: "should reject unknown function", expr: `record.eventName.startWith("Get")`, wantErr: true},
+		{name: "should reject non bool result", expr: `"Decrypt"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileExpression(tt.expr)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+var yamlWhenConfig = `
+---
+version: 2
+rules:
+  - name: cross_account_writes
+    action: keep
+    when: record.userIdentity.accountId != record.recipientAccountId && record.readOnly == false
+  - name: old_api
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: s3.amazonaws.com
+    when: record.apiVersion < 20100101
+default_action: drop
+`
+
+func TestRuleSet_EvaluateWhen(t *testing.T) {
+	rs, err := LoadAndValidate(yamlWhenConfig)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		evt      map[string]interface{}
+		want     *Decision
+		wantErrs map[string]string
+	}{
+		{
+			name: "should keep cross account write",
+			evt: map[string]interface{}{
+				"userIdentity":       map[string]interface{}{"accountId": "111111111111"},
+				"recipientAccountId": "222222222222",
+				"readOnly":           false,
+			},
+			want: &Decision{Action: ActionKeep, Rule: "cross_account_writes"},
+		},
+		{
+			name: "should not match same account write",
+			evt: map[string]interface{}{
+				"userIdentity":       map[string]interface{}{"accountId": "111111111111"},
+				"recipientAccountId": "111111111111",
+				"readOnly":           false,
+			},
+			want: &Decision{Action: ActionDrop},
+		},
+		{
+			name: "should not match when fields are missing",
+			evt:  map[string]interface{}{"readOnly": false},
+			want: &Decision{Action: ActionDrop},
+			wantErrs: map[string]string{
+				"cross_account_writes": "when expression failed: no such key: userIdentity",
+			},
+		},
+		{
+			name: "should compare json numbers",
+			evt: map[string]interface{}{
+				"eventSource": "s3.amazonaws.com",
+				"apiVersion":  json.Number("20060301"),
+			},
+			want: &Decision{Action: ActionDrop, Rule: "old_api"},
+			wantErrs: map[string]string{
+				"cross_account_writes": "when expression failed: no such key: userIdentity",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := rs.Evaluate(tt.evt)
+			assert.NoError(err)
+			assert.Equal(tt.wantErrs, expressionErrorMessages(got.ExpressionErrors))
+
+			got.ExpressionErrors = nil
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func expressionErrorMessages(exprErrs ExpressionErrors) map[string]string {
+	if exprErrs == nil {
+		return nil
+	}
+
+	msgs := make(map[string]string, len(exprErrs))
+	for name, err := range exprErrs {
+		msgs[name] = err.Error()
+	}
+
+	return msgs
+}
+
+func TestRuleSet_EvaluateWhenNumbers(t *testing.T) {
+	rs, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: above_double
+    action: tag
+    when: record.apiVersion > 5.0
+  - name: above_int
+    action: tag
+    when: record.apiVersion > 5
+  - name: equals_ten
+    action: tag
+    when: record.apiVersion == 10
+`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		version json.Number
+		want    []string
+	}{
+		{name: "should compare whole number with int and double", version: "10", want: []string{"above_double", "above_int", "equals_ten"}},
+		{name: "should compare decimal with int and double", version: "10.5", want: []string{"above_double", "above_int"}},
+		{name: "should compare whole decimal with int", version: "10.0", want: []string{"above_double", "above_int", "equals_ten"}},
+		{name: "should compare small number", version: "2", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := rs.Evaluate(map[string]interface{}{"apiVersion": tt.version})
+			assert.NoError(err)
+			assert.Nil(got.ExpressionErrors)
+			assert.Equal(tt.want, got.Tags)
+		})
+	}
+}
+
+func TestRuleSet_EvaluateWhenMisspelledField(t *testing.T) {
+	assert := require.New(t)
+
+	rs, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: cross_account
+    action: keep
+    when: record.userIdentty.accountId != record.recipientAccountId
+default_action: drop
+`)
+	assert.NoError(err)
+
+	got, err := rs.Evaluate(map[string]interface{}{
+		"userIdentity":       map[string]interface{}{"accountId": "111111111111"},
+		"recipientAccountId": "222222222222",
+	})
+	assert.NoError(err)
+	assert.Equal(ActionDrop, got.Action)
+	assert.Equal(map[string]string{"cross_account": "when expression failed: no such key: userIdentty"},
+		expressionErrorMessages(got.ExpressionErrors))
+}
+
+func TestValidateWhen(t *testing.T) {
+	assert := require.New(t)
+
+	ctr, err := Load("version: 2\nrules:\n  - name: typo\n    when: record.eventName = \"Decrypt\"\n")
+	assert.NoError(err)
+
+	err = ctr.Validate()
+	assert.Error(err)
+	assert.Contains(err.Error(), `line 4: rules[0].when in rule "typo": is not a valid expression`)
+}
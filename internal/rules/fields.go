@@ -0,0 +1,117 @@
+package rules
+
+// recordFields the top level fields of a cloudtrail record
+var recordFields = stringSet(
+	"addendum",
+	"additionalEventData",
+	"apiVersion",
+	"awsRegion",
+	"edgeDeviceDetails",
+	"errorCode",
+	"errorMessage",
+	"eventCategory",
+	"eventID",
+	"eventName",
+	"eventSource",
+	"eventTime",
+	"eventType",
+	"eventVersion",
+	"insightDetails",
+	"managementEvent",
+	"readOnly",
+	"recipientAccountId",
+	"requestID",
+	"requestParameters",
+	"resources",
+	"responseElements",
+	"serviceEventDetails",
+	"sessionCredentialFromConsole",
+	"sharedEventID",
+	"sourceIPAddress",
+	"tlsDetails",
+	"userAgent",
+	"userIdentity",
+	"vpcEndpointId",
+)
+
+// userIdentityFields the fields of the userIdentity element in a cloudtrail record
+var userIdentityFields = stringSet(
+	"accessKeyId",
+	"accountId",
+	"arn",
+	"credentialId",
+	"identityProvider",
+	"invokedBy",
+	"onBehalfOf",
+	"principalId",
+	"sessionContext",
+	"type",
+	"userName",
+)
+
+// serviceRequestParameters the request parameters emitted by commonly filtered services, fields under
+// requestParameters are only checked for the services listed here
+var serviceRequestParameters = map[string]map[string]bool{
+	"kms.amazonaws.com": stringSet(
+		"aliasName",
+		"bypassPolicyLockoutSafetyCheck",
+		"constraints",
+		"customerMasterKeySpec",
+		"description",
+		"destinationEncryptionAlgorithm",
+		"destinationEncryptionContext",
+		"destinationKeyId",
+		"encryptionAlgorithm",
+		"encryptionContext",
+		"grantId",
+		"grantTokens",
+		"granteePrincipal",
+		"keyId",
+		"keySpec",
+		"keyUsage",
+		"limit",
+		"marker",
+		"messageType",
+		"multiRegion",
+		"name",
+		"numberOfBytes",
+		"operations",
+		"origin",
+		"pendingWindowInDays",
+		"policy",
+		"policyName",
+		"retiringPrincipal",
+		"signingAlgorithm",
+		"sourceEncryptionAlgorithm",
+		"sourceEncryptionContext",
+		"sourceKeyId",
+		"tagKeys",
+		"tags",
+		"targetKeyId",
+	),
+	"sts.amazonaws.com": stringSet(
+		"durationSeconds",
+		"externalId",
+		"policy",
+		"policyArns",
+		"principalArn",
+		"providerId",
+		"roleArn",
+		"roleSessionName",
+		"serialNumber",
+		"sourceIdentity",
+		"tags",
+		"tokenCode",
+		"transitiveTagKeys",
+	),
+}
+
+func stringSet(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+
+	for _, v := range values {
+		set[v] = true
+	}
+
+	return set
+}
@@ -0,0 +1,424 @@
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Checks performed when linting the configuration
+const (
+	CheckShadowed      = "shadowed"
+	CheckMatchAll      = "match-all"
+	CheckUnanchored    = "unanchored"
+	CheckUnknownField  = "unknown-field"
+	CheckExpired       = "expired"
+	CheckRedundantKeep = "redundant-keep"
+)
+
+// matchAllSamples values used to detect patterns which match every value
+var matchAllSamples = []string{
+	"",
+	"a",
+	"Decrypt",
+	"kms.amazonaws.com",
+	"arn:aws:iam::123456789012:role/admin",
+	"10.0.0.1",
+}
+
+// regexFlags leading flags in a regular expression, such as (?i)
+var regexFlags = regexp.MustCompile(`^\(\?[a-zA-Z]+\)`)
+
+// Finding a likely mistake found when linting the configuration, these don't prevent the configuration being loaded
+type Finding struct {
+	// Source where the document containing the rule was loaded from, when merging multiple documents
+	Source string
+	// Path the path to the field in the configuration, for example rules[0].matches[1].regex
+	Path string
+	// Rule the name of the rule
+	Rule string
+	// Line the line in the YAML document, zero if unknown
+	Line int
+	// Check the check which produced the finding
+	Check string
+	// Message a human readable explanation of the problem
+	Message string
+}
+
+func (f *Finding) String() string {
+	var sb strings.Builder
+
+	if f.Source != "" {
+		sb.WriteString(f.Source + ": ")
+	}
+
+	if f.Line > 0 {
+		sb.WriteString("line " + strconv.Itoa(f.Line) + ": ")
+	}
+
+	sb.WriteString(f.Path + " in rule " + strconv.Quote(f.Rule) + ": " + f.Message + " (" + f.Check + ")")
+
+	return sb.String()
+}
+
+// Lint analyse a validated configuration for rules which are likely mistakes, such as rules which can never
+// match because an earlier rule is broader, patterns which match every value and fields which cloudtrail
+// doesn't emit. Findings are returned in the order of the rules.
+func Lint(cfg *Configuration) []*Finding {
+	ln := &linter{cfg: cfg}
+
+	// lists stored in ssm or s3 may not be loaded, so these are treated as empty
+	_ = cfg.resolve()
+
+	for i, rule := range cfg.Rules {
+		if rule.IsExpired(now()) {
+			ln.report(i, []string{"expires"}, CheckExpired,
+				fmt.Sprintf("expired on %s so the rule is disabled, remove it or extend the expiry", rule.Expires))
+		}
+
+		ln.lintCondition(i, nil, &rule.Condition, eventSources(rule))
+		ln.lintShadowed(i)
+		ln.lintRedundantKeep(i)
+	}
+
+	return ln.findings
+}
+
+type linter struct {
+	cfg      *Configuration
+	findings []*Finding
+}
+
+func (ln *linter) report(idx int, segs []string, check, msg string) {
+	segs = append([]string{fmt.Sprintf("rules[%d]", idx)}, segs...)
+	source, path, line := ln.cfg.locate(segs)
+
+	ln.findings = append(ln.findings, &Finding{
+		Source:  source,
+		Path:    path,
+		Rule:    ln.cfg.Rules[idx].Name,
+		Line:    line,
+		Check:   check,
+		Message: msg,
+	})
+}
+
+func (ln *linter) lintCondition(idx int, segs []string, cd *Condition, sources []string) {
+	for i, mt := range cd.Matches {
+		ln.lintMatch(idx, append(segs, fmt.Sprintf("matches[%d]", i)), mt, sources)
+	}
+
+	for i, sub := range cd.All {
+		ln.lintCondition(idx, append(segs, fmt.Sprintf("all[%d]", i)), sub, sources)
+	}
+
+	for i, sub := range cd.Any {
+		ln.lintCondition(idx, append(segs, fmt.Sprintf("any[%d]", i)), sub, sources)
+	}
+
+	if cd.Not != nil {
+		ln.lintCondition(idx, append(segs, "not"), cd.Not, sources)
+	}
+}
+
+func (ln *linter) lintMatch(idx int, segs []string, mt *Match, sources []string) {
+	cm, err := compileMatch(mt)
+	if err != nil {
+		return // reported by validation
+	}
+
+	if msg := unknownField(cm.path, sources); msg != "" {
+		ln.report(idx, append(segs, "field_name"), CheckUnknownField, msg)
+	}
+
+	if isMatchAll(cm) {
+		ln.report(idx, append(segs, operandName(mt)), CheckMatchAll,
+			fmt.Sprintf("%s matches every value of %s", operandName(mt), mt.FieldName))
+		return
+	}
+
+	if mt.Operator() == OpRegex {
+		if msg := unanchored(mt.regex()); msg != "" {
+			ln.report(idx, append(segs, operandName(mt)), CheckUnanchored, msg)
+		}
+	}
+}
+
+// lintShadowed report the rule if an earlier drop or keep rule matches every record it matches
+func (ln *linter) lintShadowed(idx int) {
+	rule := ln.cfg.Rules[idx]
+
+	constraints := conjunctiveMatches(&rule.Condition)
+
+	for i, prev := range ln.cfg.Rules[:idx] {
+		if prev == nil || prev.ActionOrDrop() == ActionTag || prev.When != "" || prev.IsDryRun() || prev.Status(now()) != StatusEnabled {
+			continue
+		}
+
+		if len(prev.All) > 0 || len(prev.Any) > 0 || prev.Not != nil || len(prev.Matches) == 0 {
+			continue
+		}
+
+		if covers(prev.Matches, constraints) {
+			ln.report(idx, nil, CheckShadowed,
+				fmt.Sprintf("never matches as rule %q in rules[%d] matches every record it does", prev.Name, i))
+			return
+		}
+	}
+}
+
+// lintRedundantKeep report a keep rule which has no effect, as the default action is keep and no later rule
+// could drop, sample or tag the records it stops evaluating
+func (ln *linter) lintRedundantKeep(idx int) {
+	rule := ln.cfg.Rules[idx]
+
+	if rule.ActionOrDrop() != ActionKeep || ln.cfg.DefaultActionOrKeep() != ActionKeep {
+		return
+	}
+
+	if rule.IsDryRun() || rule.Status(now()) != StatusEnabled {
+		return
+	}
+
+	for _, next := range ln.cfg.Rules[idx+1:] {
+		if next.ActionOrDrop() != ActionKeep && !next.IsDryRun() && next.Status(now()) == StatusEnabled {
+			return
+		}
+	}
+
+	ln.report(idx, []string{"action"}, CheckRedundantKeep,
+		"has no effect as default_action is keep and no later rule drops, samples or tags records")
+}
+
+// conjunctiveMatches return the matches which must all be true for the condition to be true
+func conjunctiveMatches(cd *Condition) []*Match {
+	matches := append([]*Match{}, cd.Matches...)
+
+	for _, sub := range cd.All {
+		matches = append(matches, conjunctiveMatches(sub)...)
+	}
+
+	return matches
+}
+
+// covers return true if every record satisfying all the constraints satisfies all the matches
+func covers(matches, constraints []*Match) bool {
+	for _, mt := range matches {
+		implied := false
+
+		for _, c := range constraints {
+			if implies(c, mt) {
+				implied = true
+				break
+			}
+		}
+
+		if !implied {
+			return false
+		}
+	}
+
+	return true
+}
+
+// implies return true if every record satisfying match a also satisfies match b
+func implies(a, b *Match) bool {
+	ca, err := compileMatch(a)
+	if err != nil {
+		return false
+	}
+
+	cb, err := compileMatch(b)
+	if err != nil {
+		return false
+	}
+
+	if ca.path.String() != cb.path.String() {
+		return false
+	}
+
+	// a must require the field to be present
+	if a.Operator() == OpNotExists || a.OnMissing == OnMissingMatch {
+		return false
+	}
+
+	if b.Operator() == OpExists {
+		return true
+	}
+
+	switch a.Operator() {
+	case OpEquals, OpIn:
+		values := a.values()
+		if a.Operator() == OpEquals {
+			values = []Scalar{a.Value}
+		}
+
+		if len(values) == 0 {
+			return false // a list which hasn't been loaded
+		}
+
+		for _, val := range values {
+			for _, v := range scalarValues(val) {
+				if !cb.evalValue(v) {
+					return false
+				}
+			}
+		}
+
+		return true
+	case OpRegex, OpPrefix, OpSuffix, OpGlob:
+		if isMatchAll(cb) {
+			return true // a only matches strings, booleans and numbers
+		}
+	}
+
+	av, bv := string(a.Value), string(b.Value)
+
+	switch b.Operator() {
+	case OpPrefix:
+		return a.Operator() == OpPrefix && strings.HasPrefix(av, bv)
+	case OpSuffix:
+		return a.Operator() == OpSuffix && strings.HasSuffix(av, bv)
+	case OpContains:
+		return (a.Operator() == OpPrefix || a.Operator() == OpSuffix) && strings.Contains(av, bv)
+	}
+
+	return a.Operator() == b.Operator() && a.regex() == b.regex() && a.Value == b.Value &&
+		reflect.DeepEqual(a.values(), b.values())
+}
+
+// scalarValues return the record values which are equal to the operand
+func scalarValues(s Scalar) []interface{} {
+	values := []interface{}{string(s)}
+
+	op := newOperand(s)
+
+	if op.isBool {
+		values = append(values, op.boolean)
+	}
+
+	if op.isNumber {
+		values = append(values, op.number)
+	}
+
+	return values
+}
+
+// isMatchAll return true if the pattern matches every string value
+func isMatchAll(cm *compiledMatch) bool {
+	if cm.re == nil {
+		return false
+	}
+
+	for _, s := range matchAllSamples {
+		if !cm.re.MatchString(s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unanchored return a message if the regex isn't anchored at the start or end, a leading or trailing .* is
+// treated as intentionally unanchored
+func unanchored(re string) string {
+	pattern := regexFlags.ReplaceAllString(re, "")
+
+	start := strings.HasPrefix(pattern, "^") || strings.HasPrefix(pattern, `\A`) || strings.HasPrefix(pattern, ".*")
+	end := (strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`)) || strings.HasSuffix(pattern, `\z`) ||
+		strings.HasSuffix(pattern, ".*")
+
+	switch {
+	case !start && !end:
+		return fmt.Sprintf("regex %q isn't anchored so it matches any value containing a match, anchor it with ^ and $", re)
+	case !start:
+		return fmt.Sprintf("regex %q isn't anchored at the start so it matches values with characters before a match, "+
+			"anchor it with ^ or use a leading .*", re)
+	case !end:
+		return fmt.Sprintf("regex %q isn't anchored at the end so it matches values with characters after a match, "+
+			"anchor it with $ or use a trailing .*", re)
+	}
+
+	return ""
+}
+
+// unknownField return a message if cloudtrail doesn't emit the field, fields under requestParameters are checked
+// when the rule only matches event sources with known request parameters
+func unknownField(p Path, sources []string) string {
+	if len(p) == 0 || p[0].isIndex {
+		return ""
+	}
+
+	if !recordFields[p[0].key] {
+		return fmt.Sprintf("%s is not a field in cloudtrail records", p[0].key)
+	}
+
+	if len(p) < 2 || p[1].isIndex {
+		return ""
+	}
+
+	switch p[0].key {
+	case "userIdentity":
+		if !userIdentityFields[p[1].key] {
+			return fmt.Sprintf("%s is not a field of userIdentity in cloudtrail records", p[1].key)
+		}
+	case "requestParameters":
+		if len(sources) == 0 {
+			return ""
+		}
+
+		for _, src := range sources {
+			params, ok := serviceRequestParameters[src]
+			if !ok || params[p[1].key] {
+				return ""
+			}
+		}
+
+		return fmt.Sprintf("%s is not a request parameter emitted by %s", p[1].key, strings.Join(sources, ", "))
+	}
+
+	return ""
+}
+
+// eventSources return the event sources the rule is restricted to, if any
+func eventSources(rule *Rule) []string {
+	for _, mt := range conjunctiveMatches(&rule.Condition) {
+		if strings.TrimPrefix(mt.FieldName, "$.") != "eventSource" {
+			continue
+		}
+
+		var sources []string
+
+		switch mt.Operator() {
+		case OpEquals:
+			sources = []string{string(mt.Value)}
+		case OpIn:
+			for _, val := range mt.values() {
+				sources = append(sources, string(val))
+			}
+		default:
+			continue
+		}
+
+		sort.Strings(sources)
+
+		return sources
+	}
+
+	return nil
+}
+
+func operandName(mt *Match) string {
+	if mt.Operator() == OpRegex {
+		if mt.Pattern != "" {
+			return "pattern"
+		}
+
+		return "regex"
+	}
+
+	return "value"
+}
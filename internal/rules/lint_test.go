@@ -0,0 +1,224 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want []string
+	}{
+		{
+			name: "should not report anchored rules",
+			cfg: `
+version: 2
+rules:
+  - name: kms_decrypt
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    - field_name: eventName
+      regex: "^(Decrypt|Encrypt)$"
+    - field_name: requestParameters.keyId
+      op: exists
+`,
+		},
+		{
+			name: "should report shadowed rules",
+			cfg: `
+version: 2
+rules:
+  - name: kms
+    matches:
+    - field_name: eventSource
+      op: in
+      values: [kms.amazonaws.com, sts.amazonaws.com]
+  - name: kms_decrypt
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    - field_name: eventName
+      op: equals
+      value: Decrypt
+  - name: kms_or_s3
+    any:
+      - matches:
+        - field_name: eventSource
+          op: equals
+          value: kms.amazonaws.com
+      - matches:
+        - field_name: eventSource
+          op: equals
+          value: s3.amazonaws.com
+`,
+			want: []string{`line 9: rules[1] in rule "kms_decrypt": never matches as rule "kms" in rules[0] matches every record it does (shadowed)`},
+		},
+		{
+			name: "should report prefixes covered by earlier rules",
+			cfg: `
+version: 2
+rules:
+  - name: describe
+    action: keep
+    matches:
+    - field_name: eventName
+      op: prefix
+      value: Describe
+  - name: describe_instances
+    matches:
+    - field_name: eventName
+      op: prefix
+      value: DescribeInstance
+`,
+			want: []string{`line 10: rules[1] in rule "describe_instances": never matches as rule "describe" in rules[0] matches every record it does (shadowed)`},
+		},
+		{
+			name: "should not report rules after tag rules",
+			cfg: `
+version: 2
+rules:
+  - name: all_kms
+    action: tag
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+  - name: kms_decrypt
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+`,
+		},
+		{
+			name: "should report keep rules with no later rule to override",
+			cfg: `
+version: 2
+default_action: keep
+rules:
+  - name: drop_decrypt
+    matches:
+    - field_name: eventName
+      op: equals
+      value: Decrypt
+  - name: keep_kms
+    action: keep
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+`,
+			want: []string{`line 11: rules[1].action in rule "keep_kms": has no effect as default_action is keep and no later rule drops, samples or tags records (redundant-keep)`},
+		},
+		{
+			name: "should not report keep rules when records are dropped by default",
+			cfg: `
+version: 2
+default_action: drop
+rules:
+  - name: keep_kms
+    action: keep
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+`,
+		},
+		{
+			name: "should report match all patterns",
+			cfg: `
+version: 2
+rules:
+  - name: everything
+    action: tag
+    matches:
+    - field_name: eventName
+      regex: ".*"
+    - field_name: userAgent
+      op: glob
+      value: "*"
+`,
+			want: []string{
+				`line 8: rules[0].matches[0].regex in rule "everything": regex matches every value of eventName (match-all)`,
+				`line 11: rules[0].matches[1].value in rule "everything": value matches every value of userAgent (match-all)`,
+			},
+		},
+		{
+			name: "should report unanchored patterns",
+			cfg: `
+rules:
+  - name: check_kms
+    matches:
+    - field_name: eventName
+      regex: ".*crypt"
+    - field_name: eventSource
+      regex: "kms"
+`,
+			want: []string{
+				`line 6: rules[0].matches[0].regex in rule "check_kms": regex ".*crypt" isn't anchored at the end so it matches values with characters after a match, anchor it with $ or use a trailing .* (unanchored)`,
+				`line 8: rules[0].matches[1].regex in rule "check_kms": regex "kms" isn't anchored so it matches any value containing a match, anchor it with ^ and $ (unanchored)`,
+			},
+		},
+		{
+			name: "should report unknown fields",
+			cfg: `
+version: 2
+rules:
+  - name: typos
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    - field_name: eventname
+      op: exists
+    - field_name: userIdentity.account
+      op: exists
+    - field_name: requestParameters.bucketName
+      op: exists
+`,
+			want: []string{
+				`line 9: rules[0].matches[1].field_name in rule "typos": eventname is not a field in cloudtrail records (unknown-field)`,
+				`line 11: rules[0].matches[2].field_name in rule "typos": account is not a field of userIdentity in cloudtrail records (unknown-field)`,
+				`line 13: rules[0].matches[3].field_name in rule "typos": bucketName is not a request parameter emitted by kms.amazonaws.com (unknown-field)`,
+			},
+		},
+		{
+			name: "should report expired rules",
+			cfg: `
+version: 2
+rules:
+  - name: kms
+    expires: 2021-06-30
+    when: record.eventSource == "kms.amazonaws.com"
+  - name: kms_decrypt
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+`,
+			want: []string{`line 5: rules[0].expires in rule "kms": expired on 2021-06-30 so the rule is disabled, remove it or extend the expiry (expired)`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			cfg, err := Load(tt.cfg)
+			assert.NoError(err)
+			assert.NoError(cfg.Validate())
+
+			var got []string
+			for _, f := range Lint(cfg) {
+				got = append(got, f.String())
+			}
+
+			assert.Equal(tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/s3url"
+)
+
+// List a named list of values referenced by matches using in_list, the values are either provided inline
+// or stored in a separate ssm parameter or s3 object so they can be updated independently of the rules
+type List struct {
+	Values   []Scalar `yaml:"values,omitempty"`
+	Location `yaml:",inline"`
+
+	// loaded the values read from ssm or s3
+	loaded []Scalar
+}
+
+// Location where the contents of a list or table are stored when they aren't provided inline, either a
+// separate ssm parameter or s3 object
+type Location struct {
+	SSM string `yaml:"ssm,omitempty"`
+	S3  string `yaml:"s3,omitempty" validate:"omitempty,s3-url"`
+}
+
+// s3URLPattern matches the url of an s3 object such as s3://bucket/key, this is used by the schema
+const s3URLPattern = `^s3://[^/]+/.+$`
+
+// locationFields the fields of a location, mapped to the name of the struct field used when reporting errors
+var locationFields = map[string]string{"ssm": "SSM", "s3": "S3"}
+
+// listFields the fields of a list which provide its values, exactly one must be provided
+var listFields = []string{"values", "ssm", "s3"}
+
+// isRemote return true if the contents are stored in ssm or s3
+func (l Location) isRemote() bool {
+	return l.SSM != "" || l.S3 != ""
+}
+
+// location return where the contents are stored
+func (l Location) location() string {
+	if l.S3 != "" {
+		return l.S3
+	}
+
+	return l.SSM
+}
+
+// validate report an error unless the contents are provided in exactly one way, either inline using the
+// named field or stored in ssm or s3
+func (l Location) validate(sl validator.StructLevel, name, fieldName string, inline bool) {
+	var provided []string
+
+	if inline {
+		provided = append(provided, name)
+	}
+
+	if l.SSM != "" {
+		provided = append(provided, "ssm")
+	}
+
+	if l.S3 != "" {
+		provided = append(provided, "s3")
+	}
+
+	switch len(provided) {
+	case 0:
+		sl.ReportError(nil, name, fieldName, "required_without_all", "ssm s3")
+	case 1:
+	default:
+		sl.ReportError(nil, provided[1], locationFields[provided[1]], "excluded_with", provided[0])
+	}
+}
+
+// values return the values provided inline or loaded from ssm or s3
+func (l *List) values() []Scalar {
+	if l == nil {
+		return nil
+	}
+
+	if l.isRemote() {
+		return l.loaded
+	}
+
+	return l.Values
+}
+
+// ValidateList implements validator.StructLevelFunc, ensuring the values of the list are provided in exactly one way
+func ValidateList(sl validator.StructLevel) {
+	list, ok := sl.Current().Interface().(List)
+	if !ok {
+		return
+	}
+
+	list.Location.validate(sl, "values", "Values", len(list.Values) > 0)
+}
+
+// ValidateIsS3URL implements validator.Func
+func ValidateIsS3URL(fl validator.FieldLevel) bool {
+	_, _, err := s3url.Parse(fl.Field().String())
+	return err == nil
+}
+
+// decodeList decode the values of a list stored in ssm or s3, which is a YAML or JSON list
+func decodeList(content string) ([]Scalar, error) {
+	values := []Scalar{}
+
+	err := yaml.Unmarshal([]byte(content), &values)
+	if err != nil {
+		return nil, fmt.Errorf("decode list failed: %w", err)
+	}
+
+	return values, nil
+}
+
+// patternNames return the names of the patterns in order
+func (cr *Configuration) patternNames() []string {
+	names := make([]string, 0, len(cr.Patterns))
+
+	for name := range cr.Patterns {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// listNames return the names of the lists in order
+func (cr *Configuration) listNames() []string {
+	names := make([]string, 0, len(cr.Lists))
+
+	for name := range cr.Lists {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// resolve resolve the named patterns and lists referenced by the matches in each rule and transform, returning the first
+// error if a reference is missing or a list stored in ssm or s3 hasn't been loaded
+func (cr *Configuration) resolve() error {
+	var first error
+
+	for _, rule := range cr.Rules {
+		err := cr.resolveCondition(&rule.Condition)
+		if err != nil && first == nil {
+			first = fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+
+	for _, tr := range cr.Transforms {
+		err := cr.resolveCondition(&tr.Condition)
+		if err != nil && first == nil {
+			first = fmt.Errorf("transform %s: %w", tr.Name, err)
+		}
+	}
+
+	return first
+}
+
+// resolveCondition resolve the references in every match of the condition, continuing after an error so the
+// remaining references are resolved for linting
+func (cr *Configuration) resolveCondition(cd *Condition) error {
+	var first error
+
+	check := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	for _, mt := range cd.Matches {
+		check(cr.resolveMatch(mt))
+	}
+
+	for _, sub := range cd.All {
+		check(cr.resolveCondition(sub))
+	}
+
+	for _, sub := range cd.Any {
+		check(cr.resolveCondition(sub))
+	}
+
+	if cd.Not != nil {
+		check(cr.resolveCondition(cd.Not))
+	}
+
+	return first
+}
+
+func (cr *Configuration) resolveMatch(mt *Match) error {
+	if mt.Pattern != "" {
+		pattern, ok := cr.Patterns[mt.Pattern]
+		if !ok {
+			return fmt.Errorf("pattern %s is not defined", mt.Pattern)
+		}
+
+		mt.pattern = pattern
+	}
+
+	if mt.InList != "" {
+		list, ok := cr.Lists[mt.InList]
+		if !ok || list == nil {
+			return fmt.Errorf("list %s is not defined", mt.InList)
+		}
+
+		mt.list = list
+
+		if list.isRemote() && list.loaded == nil {
+			return fmt.Errorf("list %s has not been loaded from %s", mt.InList, list.location())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const yamlPatternsConfig = `
+version: 2
+patterns:
+  ci_role: "^arn:aws:sts::[0-9]+:assumed-role/ci-[a-z-]+/.*$"
+lists:
+  trusted_accounts:
+    values: ["012345678901", "123456789012"]
+  office_ranges:
+    values: [10.0.0.0/8]
+rules:
+  - name: ci_in_trusted_accounts
+    matches:
+    - field_name: userIdentity.arn
+      pattern: ci_role
+    - field_name: recipientAccountId
+      op: in
+      in_list: trusted_accounts
+  - name: office
+    matches:
+    - field_name: sourceIPAddress
+      op: cidr
+      in_list: office_ranges
+`
+
+func TestPatternsAndLists(t *testing.T) {
+	tests := []struct {
+		name string
+		evt  map[string]interface{}
+		want string
+	}{
+		{
+			name: "should match pattern and list",
+			evt: map[string]interface{}{
+				"userIdentity":       map[string]interface{}{"arn": "arn:aws:sts::012345678901:assumed-role/ci-deploy/build"},
+				"recipientAccountId": "012345678901",
+			},
+			want: "ci_in_trusted_accounts",
+		},
+		{
+			name: "should not match account missing from list",
+			evt: map[string]interface{}{
+				"userIdentity":       map[string]interface{}{"arn": "arn:aws:sts::012345678901:assumed-role/ci-deploy/build"},
+				"recipientAccountId": "999999999999",
+			},
+		},
+		{
+			name: "should match cidr list",
+			evt:  map[string]interface{}{"sourceIPAddress": "10.1.2.3"},
+			want: "office",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			rs, err := LoadAndValidate(yamlPatternsConfig)
+			assert.NoError(err)
+
+			dec, err := rs.Evaluate(tt.evt)
+			assert.NoError(err)
+			assert.Equal(tt.want, dec.Rule)
+		})
+	}
+}
+
+func TestPatternsAndLists_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     string
+		wantErr string
+	}{
+		{
+			name: "should reject undefined pattern",
+			cfg: `
+version: 2
+rules:
+  - name: ci
+    matches:
+    - field_name: userIdentity.arn
+      pattern: ci_role
+`,
+			wantErr: `line 7: rules[0].matches[0].pattern in rule "ci": "ci_role" is not defined in patterns`,
+		},
+		{
+			name: "should reject undefined list",
+			cfg: `
+version: 2
+rules:
+  - name: trusted
+    matches:
+    - field_name: recipientAccountId
+      op: in
+      in_list: trusted_accounts
+`,
+			wantErr: `line 8: rules[0].matches[0].in_list in rule "trusted": "trusted_accounts" is not defined in lists`,
+		},
+		{
+			name: "should reject pattern with regex",
+			cfg: `
+version: 2
+patterns:
+  ci_role: "^ci-"
+rules:
+  - name: ci
+    matches:
+    - field_name: userIdentity.arn
+      regex: "^arn:"
+      pattern: ci_role
+`,
+			wantErr: `line 10: rules[0].matches[0].pattern in rule "ci": can't be used with regex`,
+		},
+		{
+			name: "should reject invalid pattern",
+			cfg: `
+version: 2
+patterns:
+  ci_role: "^(ci-"
+rules:
+  - name: ci
+    matches:
+    - field_name: userIdentity.arn
+      pattern: ci_role
+`,
+			wantErr: "line 4: patterns[ci_role]: is not a valid regular expression: error parsing regexp: missing closing ): `^(ci-`",
+		},
+		{
+			name: "should reject list without values",
+			cfg: `
+version: 2
+lists:
+  trusted_accounts: {}
+rules:
+  - name: trusted
+    matches:
+    - field_name: recipientAccountId
+      op: in
+      in_list: trusted_accounts
+`,
+			wantErr: `line 4: lists[trusted_accounts].values: at least one of ssm, s3 is required`,
+		},
+		{
+			name: "should reject list stored in more than one place",
+			cfg: `
+version: 2
+lists:
+  trusted_accounts:
+    values: ["012345678901"]
+    ssm: /config/lists/trusted_accounts
+rules:
+  - name: trusted
+    matches:
+    - field_name: recipientAccountId
+      op: in
+      in_list: trusted_accounts
+`,
+			wantErr: `line 6: lists[trusted_accounts].ssm: can't be used with values`,
+		},
+		{
+			name: "should reject invalid s3 url",
+			cfg: `
+version: 2
+lists:
+  trusted_accounts:
+    s3: config-bucket/trusted_accounts.yaml
+rules:
+  - name: trusted
+    matches:
+    - field_name: recipientAccountId
+      op: in
+      in_list: trusted_accounts
+`,
+			wantErr: `line 5: lists[trusted_accounts].s3: invalid s3 url "config-bucket/trusted_accounts.yaml", expected s3://bucket/key`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			cfg, err := Load(tt.cfg)
+			assert.NoError(err)
+			assert.EqualError(cfg.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestCompile_RemoteListNotLoaded(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := LoadAndValidate(yamlRemoteListsConfig)
+	assert.EqualError(err, "rules compile failed: rule ci_roles: list ci_roles has not been loaded from /config/lists/ci_roles")
+}
+
+func TestMerge_PatternsAndLists(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := Merge([]*Document{
+		{Source: "/rules/00-baseline", Content: yamlPatternsConfig},
+		{Source: "/rules/team-a", Content: `
+version: 2
+patterns:
+  ci_role: "^ci-"
+rules:
+  - name: team_a
+    matches:
+    - field_name: userIdentity.arn
+      pattern: ci_role
+`},
+	})
+	assert.Nil(cfg)
+	assert.EqualError(err, `/rules/team-a: line 4: patterns[ci_role]: duplicate pattern name, also defined in /rules/00-baseline`)
+
+	_, err = Merge([]*Document{
+		{Source: "/rules/00-baseline", Content: yamlPatternsConfig},
+		{Source: "/lists/trusted_accounts", Content: `["012345678901"]`, List: "trusted_accounts"},
+	})
+	assert.EqualError(err, "/lists/trusted_accounts: list trusted_accounts is not stored in ssm or s3")
+}
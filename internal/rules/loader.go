@@ -0,0 +1,187 @@
+package rules
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Policies applied when the configuration can't be loaded and no configuration has been loaded previously
+const (
+	FallbackFail        = "fail"
+	FallbackPassThrough = "pass_through"
+	FallbackDropAll     = "drop_all"
+)
+
+// Loader loads the configuration from a source, the compiled rule set is reused until the configuration
+// changes. If the configuration can't be loaded or is invalid the last known good rule set is used.
+type Loader struct {
+	src      ConfigSource
+	snapshot Snapshot
+	policy   string
+
+	mu         sync.Mutex
+	docs       []*Document
+	ruleSet    *RuleSet
+	failedDocs []*Document
+	failedErr  error
+}
+
+// LoaderOption option used to configure the loader
+type LoaderOption func(*Loader)
+
+// WithSnapshot store the last configuration which was successfully validated in the snapshot, this is
+// restored if the configuration can't be loaded when the processor starts
+func WithSnapshot(snapshot Snapshot) LoaderOption {
+	return func(ld *Loader) {
+		ld.snapshot = snapshot
+	}
+}
+
+// WithFallbackPolicy set the policy applied when no configuration has been loaded, this defaults to fail
+func WithFallbackPolicy(policy string) LoaderOption {
+	return func(ld *Loader) {
+		ld.policy = policy
+	}
+}
+
+// NewLoader create a loader for the configuration read from the source
+func NewLoader(src ConfigSource, opts ...LoaderOption) *Loader {
+	ld := &Loader{src: src, policy: FallbackFail}
+
+	for _, opt := range opts {
+		opt(ld)
+	}
+
+	return ld
+}
+
+// Load return the compiled rule set, this is only reloaded and validated if the configuration has changed, or
+// compiled again once a rule has expired
+func (ld *Loader) Load(ctx context.Context) (*RuleSet, error) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	rs, err := ld.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ld.expire(ctx, rs), nil
+}
+
+func (ld *Loader) load(ctx context.Context) (*RuleSet, error) {
+	docs, err := ld.src.Documents(ctx)
+	if err != nil {
+		return ld.fallback(ctx, err)
+	}
+
+	if ld.ruleSet != nil && sameDocuments(docs, ld.docs) {
+		return ld.ruleSet, nil
+	}
+
+	// avoid validating the same invalid configuration for every file
+	if ld.failedErr != nil && sameDocuments(docs, ld.failedDocs) {
+		return ld.fallback(ctx, ld.failedErr)
+	}
+
+	log.Ctx(ctx).Info().Strs("sources", documentSources(docs)).Msg("compiling updated config")
+
+	rs, err := MergeAndValidate(docs)
+	if err != nil {
+		ld.failedDocs, ld.failedErr = docs, err
+
+		return ld.fallback(ctx, err)
+	}
+
+	ld.docs, ld.ruleSet = docs, rs
+	ld.failedDocs, ld.failedErr = nil, nil
+
+	logExpired(ctx, rs)
+
+	if ld.snapshot != nil {
+		err = ld.snapshot.Save(ctx, docs)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("failed to save config snapshot")
+		}
+	}
+
+	return rs, nil
+}
+
+// fallback return the last known good rule set, or the rule set restored from the snapshot, otherwise
+// apply the fallback policy
+func (ld *Loader) fallback(ctx context.Context, loadErr error) (*RuleSet, error) {
+	if ld.ruleSet != nil {
+		log.Ctx(ctx).Warn().Err(loadErr).Str("fallback", "last_known_good").Msg("failed to load config, using fallback")
+		return ld.ruleSet, nil
+	}
+
+	if ld.snapshot != nil {
+		rs, err := ld.restore(ctx)
+		if err == nil {
+			log.Ctx(ctx).Warn().Err(loadErr).Str("fallback", "snapshot").Msg("failed to load config, using fallback")
+			return rs, nil
+		}
+
+		log.Ctx(ctx).Warn().Err(err).Msg("failed to restore config snapshot")
+	}
+
+	switch ld.policy {
+	case FallbackPassThrough:
+		log.Ctx(ctx).Warn().Err(loadErr).Str("fallback", FallbackPassThrough).Msg("failed to load config, using fallback")
+		return Compile(&Configuration{Version: CurrentVersion, DefaultAction: ActionKeep})
+	case FallbackDropAll:
+		log.Ctx(ctx).Warn().Err(loadErr).Str("fallback", FallbackDropAll).Msg("failed to load config, using fallback")
+		return Compile(&Configuration{Version: CurrentVersion, DefaultAction: ActionDrop})
+	default:
+		return nil, loadErr
+	}
+}
+
+// expire compile the rule set again if a rule has expired since it was compiled, the configuration has already
+// been validated so if this fails the rule set is used unchanged
+func (ld *Loader) expire(ctx context.Context, rs *RuleSet) *RuleSet {
+	if !rs.expiresBy(now()) {
+		return rs
+	}
+
+	next, err := Compile(rs.cfg)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("failed to compile config with expired rules disabled")
+		return rs
+	}
+
+	if ld.ruleSet == rs {
+		ld.ruleSet = next
+	}
+
+	logExpired(ctx, next)
+
+	return next
+}
+
+// logExpired log a warning for each rule which was disabled as it had expired
+func logExpired(ctx context.Context, rs *RuleSet) {
+	for _, rule := range rs.Expired() {
+		log.Ctx(ctx).Warn().Object("rule", rule).Msg("rule has expired and is disabled")
+	}
+}
+
+// restore load the rule set from the snapshot, this is kept as the last known good rule set
+func (ld *Loader) restore(ctx context.Context) (*RuleSet, error) {
+	docs, err := ld.snapshot.Restore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := MergeAndValidate(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	ld.ruleSet = rs
+
+	return rs, nil
+}
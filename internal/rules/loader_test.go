@@ -0,0 +1,141 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/mocks"
+)
+
+func TestLoader_Load(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ssm := mocks.NewMockCache(ctrl)
+
+	gomock.InOrder(
+		ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlConfig, nil).Times(2),
+		ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlBenchConfig, nil),
+	)
+
+	ld := NewLoader(NewSSMSource(ssm, "/config/whatever"))
+
+	first, err := ld.Load(context.TODO())
+	assert.NoError(err)
+
+	second, err := ld.Load(context.TODO())
+	assert.NoError(err)
+	assert.Same(first, second, "rule set should be reused while the config is unchanged")
+
+	third, err := ld.Load(context.TODO())
+	assert.NoError(err)
+	assert.NotSame(first, third)
+	assert.Len(third.Configuration().Rules, 3)
+}
+
+func TestLoader_LastKnownGood(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ssm := mocks.NewMockCache(ctrl)
+
+	gomock.InOrder(
+		ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlConfig, nil),
+		ssm.EXPECT().GetKey("/config/whatever", false).Return("", errors.New("throttled")),
+		ssm.EXPECT().GetKey("/config/whatever", false).Return("rules: [}", nil).Times(2),
+	)
+
+	ld := NewLoader(NewSSMSource(ssm, "/config/whatever"))
+
+	first, err := ld.Load(context.TODO())
+	assert.NoError(err)
+
+	// ssm is throttled
+	second, err := ld.Load(context.TODO())
+	assert.NoError(err)
+	assert.Same(first, second)
+
+	// the stored config is invalid
+	for i := 0; i < 2; i++ {
+		third, err := ld.Load(context.TODO())
+		assert.NoError(err)
+		assert.Same(first, third)
+	}
+}
+
+func TestLoader_Snapshot(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.NoError(err)
+
+	defer os.RemoveAll(dir)
+
+	snapshot := NewFileSnapshot(filepath.Join(dir, "snapshot.json"))
+
+	ssm := mocks.NewMockCache(ctrl)
+
+	gomock.InOrder(
+		ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlConfig, nil),
+		ssm.EXPECT().GetKey("/config/whatever", false).Return("", errors.New("throttled")),
+	)
+
+	_, err = NewLoader(NewSSMSource(ssm, "/config/whatever"), WithSnapshot(snapshot)).Load(context.TODO())
+	assert.NoError(err)
+
+	// a new loader, such as after the processor restarts, restores the snapshot
+	rs, err := NewLoader(NewSSMSource(ssm, "/config/whatever"), WithSnapshot(snapshot)).Load(context.TODO())
+	assert.NoError(err)
+	assert.Equal("check_kms", rs.Configuration().Rules[0].Name)
+	assert.Equal("/config/whatever", rs.Configuration().Rules[0].Source)
+}
+
+func TestLoader_FallbackPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		want    *Decision
+		wantErr bool
+	}{
+		{name: "should fail", policy: FallbackFail, wantErr: true},
+		{name: "should pass through", policy: FallbackPassThrough, want: &Decision{Action: ActionKeep}},
+		{name: "should drop all", policy: FallbackDropAll, want: &Decision{Action: ActionDrop}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			ssm := mocks.NewMockCache(ctrl)
+			ssm.EXPECT().GetKey("/config/whatever", false).Return("", errors.New("throttled"))
+
+			rs, err := NewLoader(NewSSMSource(ssm, "/config/whatever"), WithFallbackPolicy(tt.policy)).Load(context.TODO())
+			if tt.wantErr {
+				assert.EqualError(err, "read config from ssm failed: throttled")
+				return
+			}
+
+			assert.NoError(err)
+
+			got, err := rs.Evaluate(map[string]interface{}{"eventName": "Decrypt"})
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package rules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/mocks"
+)
+
+const yamlMetadataConfig = `
+version: 2
+rules:
+  - name: kms_decrypt
+    description: Decrypt calls made by services are high volume and not useful in the SIEM
+    owner: security-team
+    ticket: SEC-123
+    expires: "2021-06-30"
+    matches:
+    - field_name: eventName
+      op: equals
+      value: Decrypt
+    tests:
+    - name: drops decrypt
+      record: {eventName: Decrypt}
+      expect: drop
+  - name: describe
+    enabled: false
+    matches:
+    - field_name: eventName
+      op: prefix
+      value: Describe
+`
+
+func TestRule_Status(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name string
+		rule *Rule
+		at   string
+		want string
+	}{
+		{name: "should be enabled by default", rule: &Rule{}, at: "2021-06-30T12:00:00Z", want: StatusEnabled},
+		{name: "should be enabled when set", rule: &Rule{Enabled: &enabled}, at: "2021-06-30T12:00:00Z", want: StatusEnabled},
+		{name: "should be disabled when set", rule: &Rule{Enabled: &disabled, Expires: "2021-06-30"}, at: "2021-07-01T00:00:00Z", want: StatusDisabled},
+		{name: "should be enabled on the expiry date", rule: &Rule{Expires: "2021-06-30"}, at: "2021-06-30T23:59:59Z", want: StatusEnabled},
+		{name: "should be expired after the expiry date", rule: &Rule{Expires: "2021-06-30"}, at: "2021-07-01T00:00:00Z", want: StatusExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			at, err := time.Parse(time.RFC3339, tt.at)
+			assert.NoError(err)
+			assert.Equal(tt.want, tt.rule.Status(at))
+		})
+	}
+}
+
+func TestCompile_SkipsDisabledAndExpiredRules(t *testing.T) {
+	assert := require.New(t)
+
+	setNow(t, "2021-06-30T12:00:00Z")
+
+	rs, err := LoadAndValidate(yamlMetadataConfig)
+	assert.NoError(err)
+	assert.Empty(rs.Expired())
+
+	dec, err := rs.Evaluate(map[string]interface{}{"eventName": "Decrypt"})
+	assert.NoError(err)
+	assert.Equal("kms_decrypt", dec.Rule)
+	assert.Equal("security-team", rs.Rule(dec.Rule).Owner)
+
+	dec, err = rs.Evaluate(map[string]interface{}{"eventName": "DescribeKey"})
+	assert.NoError(err)
+	assert.Equal(ActionKeep, dec.Action, "disabled rule should not match")
+
+	// the tests for the expired rule are skipped
+	setNow(t, "2021-07-01T00:00:00Z")
+
+	rs, err = LoadAndValidate(yamlMetadataConfig)
+	assert.NoError(err)
+	assert.Len(rs.Expired(), 1)
+
+	dec, err = rs.Evaluate(map[string]interface{}{"eventName": "Decrypt"})
+	assert.NoError(err)
+	assert.Equal(ActionKeep, dec.Action, "expired rule should not match")
+}
+
+func TestLoader_ExpiresRules(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ssm := mocks.NewMockCache(ctrl)
+	ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlMetadataConfig, nil).Times(3)
+
+	ld := NewLoader(NewSSMSource(ssm, "/config/whatever"))
+
+	setNow(t, "2021-06-30T12:00:00Z")
+
+	first, err := ld.Load(context.TODO())
+	assert.NoError(err)
+
+	second, err := ld.Load(context.TODO())
+	assert.NoError(err)
+	assert.Same(first, second)
+
+	// the unchanged config is compiled again once the rule expires
+	setNow(t, "2021-07-01T00:00:00Z")
+
+	third, err := ld.Load(context.TODO())
+	assert.NoError(err)
+	assert.NotSame(first, third)
+	assert.Len(third.Expired(), 1)
+
+	dec, err := third.Evaluate(map[string]interface{}{"eventName": "Decrypt"})
+	assert.NoError(err)
+	assert.Equal(ActionKeep, dec.Action)
+}
+
+func TestValidateExpires(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := Load(`
+version: 2
+rules:
+  - name: kms_decrypt
+    expires: 30/06/2021
+    when: record.eventName == "Decrypt"
+`)
+	assert.NoError(err)
+	assert.EqualError(cfg.Validate(), `line 5: rules[0].expires in rule "kms_decrypt": "30/06/2021" is not a valid date, expected YYYY-MM-DD`)
+}
+
+// setNow set the time used by the rules for the duration of the test
+func setNow(t *testing.T, value string) {
+	at, err := time.Parse(time.RFC3339, value)
+	require.NoError(t, err)
+
+	prev := now
+	now = func() time.Time { return at }
+
+	t.Cleanup(func() { now = prev })
+}
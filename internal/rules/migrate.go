@@ -0,0 +1,144 @@
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion the latest version of the configuration schema, documents without a version are
+// treated as version 1
+const CurrentVersion = 2
+
+// configurationV1 version 1 of the configuration schema, which has a list of rules each containing
+// matches which are all evaluated using a regex
+type configurationV1 struct {
+	Version int       `yaml:"version"`
+	Rules   []*ruleV1 `yaml:"rules"`
+}
+
+type ruleV1 struct {
+	Name    string     `yaml:"name"`
+	Matches []*matchV1 `yaml:"matches"`
+}
+
+type matchV1 struct {
+	FieldName string `yaml:"field_name"`
+	Regex     string `yaml:"regex"`
+}
+
+// upgrade convert the version 1 configuration into the latest schema, making the default actions explicit
+func (cv *configurationV1) upgrade() *Configuration {
+	cfg := &Configuration{
+		Version:       CurrentVersion,
+		DefaultAction: ActionKeep,
+	}
+
+	// null rules and matches are kept so they are rejected by validation
+	for _, r := range cv.Rules {
+		if r == nil {
+			cfg.Rules = append(cfg.Rules, nil)
+			continue
+		}
+
+		rule := &Rule{Name: r.Name, Action: ActionDrop}
+
+		for _, m := range r.Matches {
+			if m == nil {
+				rule.Matches = append(rule.Matches, nil)
+				continue
+			}
+
+			rule.Matches = append(rule.Matches, &Match{FieldName: m.FieldName, Op: OpRegex, Regex: m.Regex})
+		}
+
+		cfg.Rules = append(cfg.Rules, rule)
+	}
+
+	return cfg
+}
+
+// documentVersion read the version from the top level of the YAML document, returning 1 if not present
+func documentVersion(node *yaml.Node) (int, error) {
+	vn := mappingValue(documentRoot(node), "version")
+	if vn == nil {
+		return 1, nil
+	}
+
+	version, err := strconv.Atoi(vn.Value)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: version %q must be a number", vn.Line, vn.Value)
+	}
+
+	return version, nil
+}
+
+// decodeVersion strictly decode the document using the schema for the version, upgrading it to the latest schema
+func decodeVersion(rawCfg string, version int) (*Configuration, error) {
+	switch version {
+	case 1:
+		cv := new(configurationV1)
+
+		err := decodeStrict(rawCfg, cv)
+		if err != nil {
+			return nil, fmt.Errorf("documents without a version use the version 1 schema, set version: %d to use newer features: %w",
+				CurrentVersion, err)
+		}
+
+		return cv.upgrade(), nil
+	case CurrentVersion:
+		cfg := new(Configuration)
+
+		err := decodeStrict(rawCfg, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return cfg, nil
+	default:
+		return nil, fmt.Errorf("unsupported configuration version %d, the latest version is %d", version, CurrentVersion)
+	}
+}
+
+// decodeStrict decode the document rejecting unknown fields
+func decodeStrict(rawCfg string, out interface{}) error {
+	dec := yaml.NewDecoder(strings.NewReader(rawCfg))
+	dec.KnownFields(true)
+
+	err := dec.Decode(out)
+	if err != nil && err != io.EOF { // an empty document is reported by validation
+		return err
+	}
+
+	return nil
+}
+
+// Migrate load the configuration, upgrading it from older versions, and return it encoded as YAML
+// using the latest schema
+func Migrate(rawCfg string) ([]byte, error) {
+	cfg, err := Load(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+
+	err = enc.Encode(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,83 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	assert := require.New(t)
+
+	out, err := Migrate(yamlConfig)
+	assert.NoError(err)
+	assert.Equal(`version: 2
+default_action: keep
+rules:
+  - name: check_kms
+    action: drop
+    matches:
+      - field_name: eventName
+        op: regex
+        regex: .*crypt
+      - field_name: eventSource
+        op: regex
+        regex: kms.*
+`, string(out))
+
+	// the migrated document should load to the same configuration
+	migrated, err := Load(string(out))
+	assert.NoError(err)
+
+	original, err := Load(yamlConfig)
+	assert.NoError(err)
+	assert.Equal(original.Rules, migrated.Rules)
+}
+
+func TestLoadVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     string
+		wantErr string
+	}{
+		{
+			name: "should load unversioned document as version 1",
+			cfg:  "rules:\n  - name: test\n    matches:\n    - {field_name: eventName, regex: Decrypt}\n",
+		},
+		{
+			name: "should load version 1",
+			cfg:  "version: 1\nrules:\n  - name: test\n    matches:\n    - {field_name: eventName, regex: Decrypt}\n",
+		},
+		{
+			name:    "should reject newer fields in version 1",
+			cfg:     "rules:\n  - name: test\n    matches:\n    - {field_name: eventName, op: equals, value: Decrypt}\n",
+			wantErr: "set version: 2 to use newer features: yaml: unmarshal errors:\n  line 4: field op not found in type rules.matchV1",
+		},
+		{
+			name:    "should reject unsupported version",
+			cfg:     "version: 3\nrules: []\n",
+			wantErr: "unsupported configuration version 3, the latest version is 2",
+		},
+		{
+			name:    "should reject invalid version",
+			cfg:     "version: two\nrules: []\n",
+			wantErr: `line 1: version "two" must be a number`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			cfg, err := Load(tt.cfg)
+			if tt.wantErr != "" {
+				assert.Error(err)
+				assert.Contains(err.Error(), tt.wantErr)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(CurrentVersion, cfg.Version)
+			assert.NoError(cfg.Validate())
+		})
+	}
+}
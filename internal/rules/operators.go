@@ -0,0 +1,494 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/segmentio/encoding/json"
+)
+
+// Operators supported by a match, if none is provided the regex operator is used
+const (
+	OpRegex     = "regex"
+	OpEquals    = "equals"
+	OpIn        = "in"
+	OpPrefix    = "prefix"
+	OpSuffix    = "suffix"
+	OpContains  = "contains"
+	OpExists    = "exists"
+	OpNotExists = "not_exists"
+	OpIsNull    = "is_null"
+	OpGt        = "gt"
+	OpLt        = "lt"
+	OpCIDR      = "cidr"
+	OpGlob      = "glob"
+)
+
+// operators the operators supported by a match, in the order they are documented
+var operators = []string{
+	OpRegex, OpEquals, OpIn, OpPrefix, OpSuffix, OpContains, OpExists, OpNotExists, OpIsNull, OpGt, OpLt, OpCIDR, OpGlob,
+}
+
+// Behaviors supported when the field in a match is missing from the event
+const (
+	OnMissingNoMatch = "no_match"
+	OnMissingMatch   = "match"
+	OnMissingError   = "error"
+)
+
+// operatorOperands the operands accepted by each operator, at least one must be provided, exists,
+// not_exists and is_null don't take an operand
+var operatorOperands = map[string][]string{
+	OpRegex:    {"pattern", "regex"},
+	OpEquals:   {"value"},
+	OpIn:       {"in_list", "values"},
+	OpPrefix:   {"value"},
+	OpSuffix:   {"value"},
+	OpContains: {"value"},
+	OpGt:       {"value"},
+	OpLt:       {"value"},
+	OpCIDR:     {"in_list", "value", "values"},
+	OpGlob:     {"value"},
+}
+
+// operandFields the operands mapped to the name of the struct field used when reporting errors
+var operandFields = map[string]string{
+	"pattern": "Pattern",
+	"regex":   "Regex",
+	"value":   "Value",
+	"values":  "Values",
+	"in_list": "InList",
+}
+
+// exclusiveOperands operands which can't be provided together, as a named pattern or list replaces
+// the operand provided inline
+var exclusiveOperands = [][]string{
+	{"regex", "pattern"},
+	{"values", "in_list"},
+}
+
+// Scalar an operand as written in the configuration, YAML booleans and numbers are accepted and compared
+// with the value in the record according to its type:
+//
+//   - strings are compared with the operand as written, so `value: 012` matches "012"
+//   - booleans are compared with an operand of true or false in any case, or the YAML 1.1 forms yes, no, on and off
+//   - numbers are compared numerically by equals and in, other operators use the number as written in the record
+//   - null is only matched by exists and is_null, it isn't treated as a missing field
+//   - lists are matched by contains when any element is equal to the operand
+//
+// Objects are only matched by exists.
+type Scalar string
+
+// ErrFieldMissing returned when a field is missing and the match is configured to error
+var ErrFieldMissing = errors.New("field missing from event")
+
+// Operator return the operator for the match, defaulting to regex
+func (mt *Match) Operator() string {
+	if mt.Op == "" {
+		return OpRegex
+	}
+
+	return mt.Op
+}
+
+// evalMissing evaluate the match for a field which is missing from the event, exists and not_exists
+// check for presence, all other operators follow on_missing which defaults to no match
+func (mt *Match) evalMissing() (bool, error) {
+	switch mt.Operator() {
+	case OpExists:
+		return false, nil
+	case OpNotExists:
+		return true, nil
+	}
+
+	switch mt.OnMissing {
+	case OnMissingMatch:
+		return true, nil
+	case OnMissingError:
+		return false, fmt.Errorf("%w: %s", ErrFieldMissing, mt.FieldName)
+	default:
+		return false, nil
+	}
+}
+
+// compiledMatch match with the field path parsed, and any regex, glob, CIDR or value operands prepared
+// ahead of evaluation
+type compiledMatch struct {
+	*Match
+	path     Path
+	re       *regexp.Regexp
+	nets     []*net.IPNet
+	operand  float64
+	operands []operand
+}
+
+// operand a value compared for equality, along with its numeric form if it is a number
+type operand struct {
+	text     string
+	number   float64
+	isNumber bool
+	boolean  bool
+	isBool   bool
+}
+
+func newOperand(s Scalar) operand {
+	n, err := strconv.ParseFloat(string(s), 64)
+	b, isBool := parseBool(string(s))
+
+	return operand{text: string(s), number: n, isNumber: err == nil, boolean: b, isBool: isBool}
+}
+
+// parseBool parse an operand written as a YAML boolean, such as True or yes, which is kept as written when decoded
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on":
+		return true, true
+	case "false", "no", "off":
+		return false, true
+	}
+
+	return false, false
+}
+
+// equal return true if the value in the record is equal to the operand, see Scalar for how types are compared
+func (op operand) equal(v interface{}) bool {
+	switch val := v.(type) {
+	case string:
+		return val == op.text
+	case bool:
+		return op.isBool && op.boolean == val
+	}
+
+	n, ok := recordNumber(v)
+
+	return ok && op.isNumber && n == op.number
+}
+
+func compileMatch(mt *Match) (*compiledMatch, error) {
+	p, err := ParsePath(mt.FieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	cm := &compiledMatch{Match: mt, path: p}
+
+	switch mt.Operator() {
+	case OpRegex:
+		cm.re, err = regexp.Compile(mt.regex())
+	case OpGlob:
+		cm.re, err = regexp.Compile(globToRegex(string(mt.Value)))
+	case OpGt, OpLt:
+		cm.operand, err = strconv.ParseFloat(string(mt.Value), 64)
+	case OpEquals, OpContains:
+		cm.operands = []operand{newOperand(mt.Value)}
+	case OpIn:
+		for _, val := range mt.values() {
+			cm.operands = append(cm.operands, newOperand(val))
+		}
+	case OpCIDR:
+		for _, cidr := range mt.cidrs() {
+			var ipnet *net.IPNet
+
+			_, ipnet, err = net.ParseCIDR(cidr)
+			if err != nil {
+				break
+			}
+
+			cm.nets = append(cm.nets, ipnet)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile match on %s: %w", mt.FieldName, err)
+	}
+
+	return cm, nil
+}
+
+// eval evaluate the match against the event
+func (cm *compiledMatch) eval(evt map[string]interface{}) (outcome, error) {
+	v, ok := cm.path.Lookup(evt)
+	if !ok {
+		match, err := cm.evalMissing()
+
+		// exists and not_exists decide on presence, so their outcome is negated like any other
+		missing := cm.Operator() != OpExists && cm.Operator() != OpNotExists
+
+		return outcome{match: match, missing: missing}, err
+	}
+
+	return outcome{match: cm.evalValue(v)}, nil
+}
+
+// evalValue evaluate the operator against a value which is present in the event
+func (cm *compiledMatch) evalValue(v interface{}) bool {
+	switch cm.Operator() {
+	case OpExists:
+		return true
+	case OpNotExists:
+		return false
+	case OpIsNull:
+		return v == nil
+	case OpGt, OpLt:
+		return cm.evalNumber(v)
+	case OpEquals, OpIn:
+		return cm.evalEquals(v)
+	case OpCIDR:
+		vs, ok := v.(string)
+		return ok && cm.evalCIDR(vs)
+	}
+
+	if elems, ok := v.([]interface{}); ok && cm.Operator() == OpContains {
+		return cm.evalEquals(elems...)
+	}
+
+	// the remaining operators compare the text of strings, booleans and numbers
+	vs, ok := recordText(v)
+	if !ok {
+		return false
+	}
+
+	switch cm.Operator() {
+	case OpPrefix:
+		return strings.HasPrefix(vs, string(cm.Value))
+	case OpSuffix:
+		return strings.HasSuffix(vs, string(cm.Value))
+	case OpContains:
+		return strings.Contains(vs, string(cm.Value))
+	default:
+		// both regex and glob are compiled to a regex
+		return cm.re.MatchString(vs)
+	}
+}
+
+// evalEquals return true if any of the values is equal to any of the operands
+func (cm *compiledMatch) evalEquals(values ...interface{}) bool {
+	for _, v := range values {
+		for _, op := range cm.operands {
+			if op.equal(v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (cm *compiledMatch) evalNumber(v interface{}) bool {
+	n, ok := toFloat(v)
+	if !ok {
+		return false
+	}
+
+	if cm.Operator() == OpGt {
+		return n > cm.operand
+	}
+
+	return n < cm.operand
+}
+
+func (cm *compiledMatch) evalCIDR(vs string) bool {
+	ip := net.ParseIP(vs)
+	if ip == nil {
+		// sourceIPAddress may contain a service name such as ec2.amazonaws.com
+		return false
+	}
+
+	for _, ipnet := range cm.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cidrs return the list of CIDR ranges provided in value, along with values or the named list
+func (mt *Match) cidrs() []string {
+	var cidrs []string
+
+	if mt.Value != "" {
+		cidrs = append(cidrs, string(mt.Value))
+	}
+
+	for _, val := range mt.values() {
+		cidrs = append(cidrs, string(val))
+	}
+
+	return cidrs
+}
+
+// regex return the regex provided inline, or the named pattern it references
+func (mt *Match) regex() string {
+	if mt.Pattern != "" {
+		return mt.pattern
+	}
+
+	return mt.Regex
+}
+
+// values return the values provided inline, or the values of the named list it references
+func (mt *Match) values() []Scalar {
+	if mt.InList != "" {
+		return mt.list.values()
+	}
+
+	return mt.Values
+}
+
+// toFloat return the value as a number for gt and lt, which also accept numeric strings
+func toFloat(v interface{}) (float64, bool) {
+	if s, ok := v.(string); ok {
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+
+	return recordNumber(v)
+}
+
+// recordNumber return the value if it is a number, records are decoded with json.Number
+func recordNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// recordText return the text of strings, booleans and numbers in the record, numbers decoded with json.Number
+// keep the text as written in the record
+func recordText(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case bool:
+		return strconv.FormatBool(val), true
+	case json.Number:
+		return val.String(), true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(val), true
+	case int64:
+		return strconv.FormatInt(val, 10), true
+	default:
+		return "", false
+	}
+}
+
+// globToRegex convert a glob into an anchored regex, where `*` matches any sequence of
+// characters, including `/` and `:` which are common in ARNs, and `?` matches a single character
+func globToRegex(glob string) string {
+	var sb strings.Builder
+
+	sb.WriteByte('^')
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteByte('.')
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	sb.WriteByte('$')
+
+	return sb.String()
+}
+
+// ValidateMatch implements validator.StructLevelFunc, checking the operands provided for the match operator
+func ValidateMatch(sl validator.StructLevel) {
+	mt, ok := sl.Current().Interface().(Match)
+	if !ok {
+		return
+	}
+
+	op := mt.Operator()
+
+	// an empty regex matches everything which is rarely intended, so it is required like other operands
+	if operands := operatorOperands[op]; len(operands) > 0 && !mt.hasOperand(operands) {
+		name := operands[len(operands)-1]
+		sl.ReportError(nil, name, operandFields[name], "required", op)
+	}
+
+	for _, names := range exclusiveOperands {
+		if mt.hasOperand(names[:1]) && mt.hasOperand(names[1:]) {
+			sl.ReportError(nil, names[1], operandFields[names[1]], "excluded_with", names[0])
+		}
+	}
+
+	// references are checked against the configuration being validated
+	if cfg, ok := sl.Top().Interface().(*Configuration); ok {
+		if _, ok := cfg.Patterns[mt.Pattern]; mt.Pattern != "" && !ok {
+			sl.ReportError(mt.Pattern, "pattern", "Pattern", "defined", "patterns")
+		}
+
+		if _, ok := cfg.Lists[mt.InList]; mt.InList != "" && !ok {
+			sl.ReportError(mt.InList, "in_list", "InList", "defined", "lists")
+		}
+	}
+
+	switch op {
+	case OpGt, OpLt:
+		if _, err := strconv.ParseFloat(string(mt.Value), 64); mt.Value != "" && err != nil {
+			sl.ReportError(mt.Value, "value", "Value", "numeric", op)
+		}
+	case OpCIDR:
+		// ranges in named lists are checked when compiled, as lists may be loaded separately
+		if _, _, err := net.ParseCIDR(string(mt.Value)); mt.Value != "" && err != nil {
+			sl.ReportError(mt.Value, "value", "Value", "cidr", string(mt.Value))
+		}
+
+		for _, cidr := range mt.Values {
+			if _, _, err := net.ParseCIDR(string(cidr)); err != nil {
+				sl.ReportError(mt.Values, "values", "Values", "cidr", string(cidr))
+			}
+		}
+	}
+}
+
+// hasOperand return true if any of the named operands is provided
+func (mt *Match) hasOperand(operands []string) bool {
+	for _, name := range operands {
+		switch name {
+		case "regex":
+			if mt.Regex != "" {
+				return true
+			}
+		case "pattern":
+			if mt.Pattern != "" {
+				return true
+			}
+		case "in_list":
+			if mt.InList != "" {
+				return true
+			}
+		case "value":
+			if mt.Value != "" {
+				return true
+			}
+		case "values":
+			if len(mt.Values) > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,249 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+var operatorEvent = map[string]interface{}{
+	"eventName":       "Decrypt",
+	"eventSource":     "kms.amazonaws.com",
+	"sourceIPAddress": "10.1.2.3",
+	"apiVersion":      json.Number("20140630"),
+	"userIdentity": map[string]interface{}{
+		"arn": "arn:aws:sts::123456789012:assumed-role/ci-deploy/session",
+	},
+}
+
+func TestMatch_Operators(t *testing.T) {
+	tests := []struct {
+		name  string
+		match *Match
+		want  bool
+	}{
+		{name: "regex should match", match: &Match{FieldName: "eventSource", Regex: "^kms\\."}, want: true},
+		{name: "equals should match", match: &Match{FieldName: "eventSource", Op: OpEquals, Value: "kms.amazonaws.com"}, want: true},
+		{name: "equals should not match partial", match: &Match{FieldName: "eventSource", Op: OpEquals, Value: "kms"}},
+		{name: "in should match", match: &Match{FieldName: "eventName", Op: OpIn, Values: []Scalar{"Encrypt", "Decrypt"}}, want: true},
+		{name: "in should not match", match: &Match{FieldName: "eventName", Op: OpIn, Values: []Scalar{"Encrypt"}}},
+		{name: "prefix should match", match: &Match{FieldName: "eventSource", Op: OpPrefix, Value: "kms."}, want: true},
+		{name: "prefix should not match", match: &Match{FieldName: "eventSource", Op: OpPrefix, Value: "xkms"}},
+		{name: "suffix should match", match: &Match{FieldName: "eventSource", Op: OpSuffix, Value: ".amazonaws.com"}, want: true},
+		{name: "contains should match", match: &Match{FieldName: "userIdentity.arn", Op: OpContains, Value: "ci-deploy"}, want: true},
+		{name: "exists should match", match: &Match{FieldName: "userIdentity.arn", Op: OpExists}, want: true},
+		{name: "exists should not match missing", match: &Match{FieldName: "errorCode", Op: OpExists}},
+		{name: "not_exists should match missing", match: &Match{FieldName: "errorCode", Op: OpNotExists}, want: true},
+		{name: "not_exists should not match present", match: &Match{FieldName: "eventName", Op: OpNotExists}},
+		{name: "gt should match number", match: &Match{FieldName: "apiVersion", Op: OpGt, Value: "20000000"}, want: true},
+		{name: "lt should not match number", match: &Match{FieldName: "apiVersion", Op: OpLt, Value: "20000000"}},
+		{name: "cidr should match", match: &Match{FieldName: "sourceIPAddress", Op: OpCIDR, Values: []Scalar{"192.168.0.0/16", "10.0.0.0/8"}}, want: true},
+		{name: "cidr should not match", match: &Match{FieldName: "sourceIPAddress", Op: OpCIDR, Value: "192.168.0.0/16"}},
+		{name: "glob should match", match: &Match{FieldName: "userIdentity.arn", Op: OpGlob, Value: "arn:aws:sts::*:assumed-role/ci-*"}, want: true},
+		{name: "glob should be anchored", match: &Match{FieldName: "userIdentity.arn", Op: OpGlob, Value: "assumed-role/ci-*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			rule := &Rule{Name: "test", Condition: Condition{Matches: []*Match{tt.match}}}
+
+			got, err := evalCondition(&rule.Condition, operatorEvent)
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestMatch_CIDRServiceName(t *testing.T) {
+	assert := require.New(t)
+
+	cm, err := compileMatch(&Match{FieldName: "sourceIPAddress", Op: OpCIDR, Value: "0.0.0.0/0"})
+	assert.NoError(err)
+	assert.False(cm.evalValue("ec2.amazonaws.com"))
+}
+
+func TestValidateMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   string
+		wantErr bool
+	}{
+		{name: "should accept regex without op", match: `{field_name: eventName, regex: "^Get"}`},
+		{name: "should accept equals", match: `{field_name: eventName, op: equals, value: GetObject}`},
+		{name: "should accept exists without value", match: `{field_name: errorCode, op: exists}`},
+		{name: "should accept cidr list", match: `{field_name: sourceIPAddress, op: cidr, values: ["10.0.0.0/8"]}`},
+		{name: "should reject unknown op", match: `{field_name: eventName, op: like, value: Get}`, wantErr: true},
+		{name: "should reject equals without value", match: `{field_name: eventName, op: equals}`, wantErr: true},
+		{name: "should reject in without values", match: `{field_name: eventName, op: in}`, wantErr: true},
+		{name: "should reject gt with non numeric value", match: `{field_name: apiVersion, op: gt, value: abc}`, wantErr: true},
+		{name: "should reject invalid cidr", match: `{field_name: sourceIPAddress, op: cidr, value: "10.0.0.0/33"}`, wantErr: true},
+		{name: "should reject cidr without ranges", match: `{field_name: sourceIPAddress, op: cidr}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ctr, err := Load("version: 2\nrules:\n  - name: test\n    matches:\n    - " + tt.match + "\n")
+			assert.NoError(err)
+
+			err = ctr.Validate()
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestValidateMatch_InvalidCIDRPath(t *testing.T) {
+	assert := require.New(t)
+
+	ctr, err := Load(`
+version: 2
+rules:
+  - name: test
+    matches:
+    - field_name: sourceIPAddress
+      op: cidr
+      value: 10.0.0.0/33
+      values: ["10.0.0.0/8", "192.168.0.0/40"]
+`)
+	assert.NoError(err)
+	assert.EqualError(ctr.Validate(), `line 8: rules[0].matches[0].value in rule "test": "10.0.0.0/33" is not a valid CIDR range; `+
+		`line 9: rules[0].matches[0].values in rule "test": "192.168.0.0/40" is not a valid CIDR range`)
+}
+
+func TestMatch_OnMissing(t *testing.T) {
+	evt := map[string]interface{}{
+		"eventName":   "Decrypt",
+		"eventSource": "kms.amazonaws.com",
+	}
+
+	tests := []struct {
+		name    string
+		match   *Match
+		want    bool
+		wantErr error
+	}{
+		{name: "should default to no match", match: &Match{FieldName: "recipientAccountId", Regex: ".*"}},
+		{name: "should not match with no_match", match: &Match{FieldName: "recipientAccountId", Regex: ".*", OnMissing: OnMissingNoMatch}},
+		{name: "should match with match", match: &Match{FieldName: "recipientAccountId", Regex: "^123", OnMissing: OnMissingMatch}, want: true},
+		{name: "should return error with error", match: &Match{FieldName: "recipientAccountId", Regex: ".*", OnMissing: OnMissingError}, wantErr: ErrFieldMissing},
+		{name: "should ignore on_missing for exists", match: &Match{FieldName: "recipientAccountId", Op: OpExists, OnMissing: OnMissingMatch}},
+		{name: "should ignore on_missing for not_exists", match: &Match{FieldName: "recipientAccountId", Op: OpNotExists, OnMissing: OnMissingError}, want: true},
+		{name: "should not match non string value", match: &Match{FieldName: "userIdentity", Regex: ".*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			rule := &Rule{Name: "test", Condition: Condition{Matches: []*Match{
+				{FieldName: "eventSource", Op: OpEquals, Value: "kms.amazonaws.com"},
+				tt.match,
+			}}}
+
+			got, err := evalCondition(&rule.Condition, evt)
+			if tt.wantErr != nil {
+				assert.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+// typedEvent decoded the same way as records in a cloudtrail log, numbers are decoded as json.Number
+const typedEvent = `{
+	"eventSource": "s3.amazonaws.com",
+	"recipientAccountId": "012345678901",
+	"eventVersion": "1.08",
+	"apiVersion": 20060301,
+	"readOnly": true,
+	"managementEvent": false,
+	"errorCode": null,
+	"additionalEventData": {"bytesTransferredIn": 0.5},
+	"resources": [{"type": "AWS::S3::Bucket"}],
+	"tlsDetails": {"cipherSuites": ["ECDHE-RSA-AES128-GCM-SHA256", 1]}
+}`
+
+func TestMatch_TypedValues(t *testing.T) {
+	var evt map[string]interface{}
+
+	_, err := json.Parse([]byte(typedEvent), &evt, json.UseNumber)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		match string
+		want  bool
+	}{
+		// strings are compared with the operand as written
+		{name: "should match string as written", match: `{field_name: recipientAccountId, op: equals, value: 012345678901}`, want: true},
+		{name: "should match numeric looking string as written", match: `{field_name: eventVersion, op: equals, value: 1.08}`, want: true},
+		{name: "should not match numeric string numerically", match: `{field_name: eventVersion, op: equals, value: 1.080}`},
+		{name: "should not match boolean operand against string", match: `{field_name: eventSource, op: equals, value: true}`},
+
+		// booleans are compared with true or false
+		{name: "should match boolean true", match: `{field_name: readOnly, op: equals, value: true}`, want: true},
+		{name: "should match boolean false", match: `{field_name: managementEvent, op: equals, value: false}`, want: true},
+		{name: "should match quoted boolean", match: `{field_name: readOnly, op: equals, value: "true"}`, want: true},
+		{name: "should match capitalized boolean", match: `{field_name: readOnly, op: equals, value: True}`, want: true},
+		{name: "should match yaml 1.1 boolean", match: `{field_name: managementEvent, op: equals, value: no}`, want: true},
+		{name: "should not match capitalized boolean against string", match: `{field_name: eventSource, op: equals, value: True}`},
+		{name: "should match other spellings of booleans", match: `{field_name: readOnly, op: in, values: ["yes"]}`, want: true},
+		{name: "should not match numbers against booleans", match: `{field_name: readOnly, op: in, values: [1, "1", "t"]}`},
+		{name: "should match boolean text with regex", match: `{field_name: managementEvent, regex: "^false$"}`, want: true},
+
+		// numbers are compared numerically by equals and in, other operators use the number as written in the record
+		{name: "should match number", match: `{field_name: apiVersion, op: equals, value: 20060301}`, want: true},
+		{name: "should match number in list", match: `{field_name: apiVersion, op: in, values: [20140630, 20060301.0]}`, want: true},
+		{name: "should match fraction", match: `{field_name: additionalEventData.bytesTransferredIn, op: equals, value: 0.50}`, want: true},
+		{name: "should not match non numeric operand against number", match: `{field_name: apiVersion, op: equals, value: v20060301}`},
+		{name: "should match number text with prefix", match: `{field_name: apiVersion, op: prefix, value: 2006}`, want: true},
+		{name: "should match number with gt", match: `{field_name: apiVersion, op: gt, value: 20000000}`, want: true},
+		{name: "should not match boolean with gt", match: `{field_name: readOnly, op: gt, value: 0}`},
+
+		// null is present but only matched by exists and is_null
+		{name: "should match null with is_null", match: `{field_name: errorCode, op: is_null}`, want: true},
+		{name: "should match null with exists", match: `{field_name: errorCode, op: exists}`, want: true},
+		{name: "should not match null with not_exists", match: `{field_name: errorCode, op: not_exists}`},
+		{name: "should not match null with regex", match: `{field_name: errorCode, regex: ".*"}`},
+		{name: "should not match null with on_missing", match: `{field_name: errorCode, regex: ".*", on_missing: match}`},
+		{name: "should not match string with is_null", match: `{field_name: eventSource, op: is_null}`},
+		{name: "should follow on_missing with is_null", match: `{field_name: errorMessage, op: is_null, on_missing: match}`, want: true},
+
+		// lists are matched by contains when any element is equal, objects are only matched by exists
+		{name: "should match list element with contains", match: `{field_name: tlsDetails.cipherSuites, op: contains, value: ECDHE-RSA-AES128-GCM-SHA256}`, want: true},
+		{name: "should match numeric list element with contains", match: `{field_name: tlsDetails.cipherSuites, op: contains, value: 1}`, want: true},
+		{name: "should not match partial list element with contains", match: `{field_name: tlsDetails.cipherSuites, op: contains, value: ECDHE}`},
+		{name: "should not match list with equals", match: `{field_name: tlsDetails.cipherSuites, op: equals, value: "1"}`},
+		{name: "should not match object with regex", match: `{field_name: "resources[0]", regex: ".*"}`},
+		{name: "should match object with exists", match: `{field_name: "resources[0]", op: exists}`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ctr, err := Load("version: 2\nrules:\n  - name: test\n    matches:\n    - " + tt.match + "\n")
+			assert.NoError(err)
+			assert.NoError(ctr.Validate())
+
+			got, err := evalCondition(&ctr.Rules[0].Condition, evt)
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestValidateMatch_UnknownOperator(t *testing.T) {
+	ctr, err := Load("version: 2\nrules:\n  - name: test\n    matches:\n    - {field_name: eventName, op: like, value: Get}\n")
+	require.NoError(t, err)
+	require.EqualError(t, ctr.Validate(), `line 5: rules[0].matches[0].op in rule "test": "like" is not valid, `+
+		`must be one of regex, equals, in, prefix, suffix, contains, exists, not_exists, is_null, gt, lt, cidr, glob`)
+}
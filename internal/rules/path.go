@@ -0,0 +1,241 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPath returned when a field path can't be parsed
+var ErrInvalidPath = errors.New("invalid field path")
+
+// FieldPathPattern regular expression matching the field paths accepted by ParsePath
+const FieldPathPattern = `^(\$\.)?[^.\[]+(\[[0-9]+\])*(\.[^.\[]+(\[[0-9]+\])*)*$`
+
+// Path field path used to locate a value in a cloudtrail record, this supports
+// dotted paths such as `userIdentity.sessionContext.sessionIssuer.userName` and array
+// indexing such as `resources[0].ARN`, an optional JSONPath style `$.` prefix is ignored.
+type Path []pathSegment
+
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// ParsePath parse the provided field path
+func ParsePath(s string) (Path, error) {
+	raw := strings.TrimPrefix(s, "$.")
+	if raw == "" {
+		return nil, fmt.Errorf("%w: %q is empty", ErrInvalidPath, s)
+	}
+
+	var p Path
+
+	for _, part := range strings.Split(raw, ".") {
+		key := part
+		idx := strings.IndexByte(part, '[')
+		if idx != -1 {
+			key = part[:idx]
+		}
+
+		if key == "" {
+			return nil, fmt.Errorf("%w: %q contains an empty field name", ErrInvalidPath, s)
+		}
+
+		p = append(p, pathSegment{key: key})
+
+		if idx == -1 {
+			continue
+		}
+
+		// consume one or more array indexes, for example [0][1]
+		rest := part[idx:]
+		for rest != "" {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end == -1 {
+				return nil, fmt.Errorf("%w: %q has an unterminated index", ErrInvalidPath, s)
+			}
+
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || strings.Trim(rest[1:end], "0123456789") != "" {
+				return nil, fmt.Errorf("%w: %q has an invalid index %q", ErrInvalidPath, s, rest[1:end])
+			}
+
+			p = append(p, pathSegment{index: n, isIndex: true})
+			rest = rest[end+1:]
+		}
+	}
+
+	return p, nil
+}
+
+// Lookup return the value at the path in the provided event, and whether it was present
+func (p Path) Lookup(evt map[string]interface{}) (interface{}, bool) {
+	var cur interface{} = evt
+
+	for _, seg := range p {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			if seg.isIndex {
+				return nil, false
+			}
+
+			next, ok := v[seg.key]
+			if !ok {
+				return nil, false
+			}
+
+			cur = next
+		case []interface{}:
+			if !seg.isIndex || seg.index >= len(v) {
+				return nil, false
+			}
+
+			cur = v[seg.index]
+		default:
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+// String return the canonical string form of the path
+func (p Path) String() string {
+	var sb strings.Builder
+
+	for i, seg := range p {
+		if seg.isIndex {
+			sb.WriteString("[" + strconv.Itoa(seg.index) + "]")
+			continue
+		}
+
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+
+		sb.WriteString(seg.key)
+	}
+
+	return sb.String()
+}
+
+// Set replace the value at the path in the provided event, returning false if it isn't present
+func (p Path) Set(evt map[string]interface{}, value interface{}) bool {
+	if len(p) == 0 {
+		return false
+	}
+
+	parent, ok := p[:len(p)-1].Lookup(evt)
+	if !ok {
+		return false
+	}
+
+	last := p[len(p)-1]
+
+	switch v := parent.(type) {
+	case map[string]interface{}:
+		if last.isIndex {
+			return false
+		}
+
+		if _, ok := v[last.key]; !ok {
+			return false
+		}
+
+		v[last.key] = value
+	case []interface{}:
+		if !last.isIndex || last.index >= len(v) {
+			return false
+		}
+
+		v[last.index] = value
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Delete remove the value at the path from the provided event, returning false if it isn't present. Removing
+// an element of an array shifts the elements which follow it.
+func (p Path) Delete(evt map[string]interface{}) bool {
+	if len(p) == 0 {
+		return false
+	}
+
+	parent, ok := p[:len(p)-1].Lookup(evt)
+	if !ok {
+		return false
+	}
+
+	last := p[len(p)-1]
+
+	switch v := parent.(type) {
+	case map[string]interface{}:
+		if last.isIndex {
+			return false
+		}
+
+		if _, ok := v[last.key]; !ok {
+			return false
+		}
+
+		delete(v, last.key)
+
+		return true
+	case []interface{}:
+		if !last.isIndex || last.index >= len(v) {
+			return false
+		}
+
+		elems := append(append(make([]interface{}, 0, len(v)-1), v[:last.index]...), v[last.index+1:]...)
+
+		return p[:len(p)-1].Set(evt, elems)
+	}
+
+	return false
+}
+
+// Project copy the value at the path in src to the same path in dst, adding the objects and arrays containing
+// it, returning false if it isn't present. Elements of an array before the index are null.
+func (p Path) Project(dst, src map[string]interface{}) bool {
+	v, ok := p.Lookup(src)
+	if !ok || len(p) == 0 {
+		return false
+	}
+
+	project(dst, p, v)
+
+	return true
+}
+
+func project(cur interface{}, p Path, v interface{}) interface{} {
+	if len(p) == 0 {
+		return v
+	}
+
+	seg := p[0]
+
+	if seg.isIndex {
+		elems, _ := cur.([]interface{})
+		for len(elems) <= seg.index {
+			elems = append(elems, nil)
+		}
+
+		elems[seg.index] = project(elems[seg.index], p[1:], v)
+
+		return elems
+	}
+
+	fields, ok := cur.(map[string]interface{})
+	if !ok {
+		fields = make(map[string]interface{})
+	}
+
+	fields[seg.key] = project(fields[seg.key], p[1:], v)
+
+	return fields
+}
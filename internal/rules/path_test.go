@@ -0,0 +1,172 @@
+package rules
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var pathEvent = map[string]interface{}{
+	"eventName": "PutObject",
+	"userIdentity": map[string]interface{}{
+		"type": "AssumedRole",
+		"sessionContext": map[string]interface{}{
+			"sessionIssuer": map[string]interface{}{
+				"userName": "ci-deploy",
+			},
+		},
+	},
+	"resources": []interface{}{
+		map[string]interface{}{"ARN": "arn:aws:s3:::testbucket"},
+		map[string]interface{}{"ARN": "arn:aws:s3:::testbucket/test"},
+	},
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "should parse top level field", path: "eventName", want: "eventName"},
+		{name: "should parse nested field", path: "userIdentity.sessionContext.sessionIssuer.userName", want: "userIdentity.sessionContext.sessionIssuer.userName"},
+		{name: "should parse array index", path: "resources[0].ARN", want: "resources[0].ARN"},
+		{name: "should strip jsonpath prefix", path: "$.userIdentity.type", want: "userIdentity.type"},
+		{name: "should reject empty path", path: "", wantErr: true},
+		{name: "should reject empty field", path: "userIdentity..type", wantErr: true},
+		{name: "should reject trailing dot", path: "userIdentity.", wantErr: true},
+		{name: "should reject unterminated index", path: "resources[0", wantErr: true},
+		{name: "should reject invalid index", path: "resources[a].ARN", wantErr: true},
+		{name: "should reject negative index", path: "resources[-1].ARN", wantErr: true},
+		{name: "should reject signed index", path: "resources[+1].ARN", wantErr: true},
+		{name: "should reject text after index", path: "resources[0]ARN", wantErr: true},
+		{name: "should reject empty prefix", path: "$.", wantErr: true},
+	}
+	pattern := regexp.MustCompile(FieldPathPattern)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			// the pattern used in the JSON schema must agree with the parser
+			assert.Equal(!tt.wantErr, pattern.MatchString(tt.path))
+
+			got, err := ParsePath(tt.path)
+			if tt.wantErr {
+				assert.ErrorIs(err, ErrInvalidPath)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tt.want, got.String())
+		})
+	}
+}
+
+func TestPath_Lookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		want   interface{}
+		wantOk bool
+	}{
+		{name: "should find top level field", path: "eventName", want: "PutObject", wantOk: true},
+		{name: "should find nested field", path: "userIdentity.sessionContext.sessionIssuer.userName", want: "ci-deploy", wantOk: true},
+		{name: "should find indexed field", path: "resources[1].ARN", want: "arn:aws:s3:::testbucket/test", wantOk: true},
+		{name: "should not find missing field", path: "userIdentity.invokedBy"},
+		{name: "should not find out of range index", path: "resources[2].ARN"},
+		{name: "should not index a map", path: "userIdentity[0]"},
+		{name: "should not descend into a string", path: "eventName.value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			p, err := ParsePath(tt.path)
+			assert.NoError(err)
+
+			got, ok := p.Lookup(pathEvent)
+			assert.Equal(tt.wantOk, ok)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestPath_SetAndDelete(t *testing.T) {
+	newEvent := func() map[string]interface{} {
+		return map[string]interface{}{
+			"eventName": "PutObject",
+			"requestParameters": map[string]interface{}{
+				"bucketName": "testbucket",
+			},
+			"resources": []interface{}{"a", "b", "c"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		delete bool
+		want   map[string]interface{}
+		wantOk bool
+	}{
+		{
+			name:   "should set nested field",
+			path:   "requestParameters.bucketName",
+			want:   map[string]interface{}{"eventName": "PutObject", "requestParameters": map[string]interface{}{"bucketName": "x"}, "resources": []interface{}{"a", "b", "c"}},
+			wantOk: true,
+		},
+		{
+			name:   "should set indexed field",
+			path:   "resources[1]",
+			want:   map[string]interface{}{"eventName": "PutObject", "requestParameters": map[string]interface{}{"bucketName": "testbucket"}, "resources": []interface{}{"a", "x", "c"}},
+			wantOk: true,
+		},
+		{
+			name:   "should delete nested field",
+			path:   "requestParameters.bucketName",
+			delete: true,
+			want:   map[string]interface{}{"eventName": "PutObject", "requestParameters": map[string]interface{}{}, "resources": []interface{}{"a", "b", "c"}},
+			wantOk: true,
+		},
+		{
+			name:   "should delete indexed field",
+			path:   "resources[1]",
+			delete: true,
+			want:   map[string]interface{}{"eventName": "PutObject", "requestParameters": map[string]interface{}{"bucketName": "testbucket"}, "resources": []interface{}{"a", "c"}},
+			wantOk: true,
+		},
+		{
+			name: "should not set missing field",
+			path: "requestParameters.key",
+			want: newEvent(),
+		},
+		{
+			name:   "should not delete out of range index",
+			path:   "resources[3]",
+			delete: true,
+			want:   newEvent(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			p, err := ParsePath(tt.path)
+			assert.NoError(err)
+
+			evt := newEvent()
+
+			var ok bool
+			if tt.delete {
+				ok = p.Delete(evt)
+			} else {
+				ok = p.Set(evt, "x")
+			}
+
+			assert.Equal(tt.wantOk, ok)
+			assert.Equal(tt.want, evt)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package rules
+
+// Projection the fields kept in each retained record, all other fields are removed to reduce the size of
+// the clean feed. The fields for an event source replace the default fields for records from that source.
+type Projection struct {
+	Fields       []string            `yaml:"fields" validate:"required,min=1,dive,field-path"`
+	EventSources map[string][]string `yaml:"event_sources,omitempty" validate:"omitempty,dive,required,min=1,dive,field-path"`
+}
+
+type compiledProjection struct {
+	fields   []Path
+	bySource map[string][]Path
+}
+
+func compileProjection(pr *Projection) (*compiledProjection, error) {
+	cp := &compiledProjection{bySource: make(map[string][]Path)}
+
+	var err error
+
+	cp.fields, err = parsePaths(pr.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	for src, fields := range pr.EventSources {
+		cp.bySource[src], err = parsePaths(fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cp, nil
+}
+
+func parsePaths(fields []string) ([]Path, error) {
+	paths := make([]Path, len(fields))
+
+	for i, fld := range fields {
+		p, err := ParsePath(fld)
+		if err != nil {
+			return nil, err
+		}
+
+		paths[i] = p
+	}
+
+	return paths, nil
+}
+
+// Project return a record containing only the fields in the projection, or the record unchanged and false
+// if no projection is configured
+func (rs *RuleSet) Project(evt map[string]interface{}) (map[string]interface{}, bool) {
+	if rs.projection == nil {
+		return evt, false
+	}
+
+	paths := rs.projection.fields
+
+	if src, ok := evt["eventSource"].(string); ok {
+		if override, ok := rs.projection.bySource[src]; ok {
+			paths = override
+		}
+	}
+
+	out := make(map[string]interface{}, len(paths))
+
+	for _, p := range paths {
+		p.Project(out, evt)
+	}
+
+	return out, true
+}
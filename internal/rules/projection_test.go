@@ -0,0 +1,131 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var yamlProjectionConfig = `
+version: 2
+rules:
+  - name: drop_describe
+    matches:
+    - field_name: eventName
+      op: prefix
+      value: Describe
+projection:
+  fields:
+  - eventTime
+  - eventName
+  - eventSource
+  - userIdentity.arn
+  - userIdentity.type
+  - x_tags
+  event_sources:
+    s3.amazonaws.com:
+    - eventTime
+    - eventName
+    - eventSource
+    - requestParameters.bucketName
+    - resources[1].ARN
+`
+
+func TestRuleSet_Project(t *testing.T) {
+	rs, err := LoadAndValidate(yamlProjectionConfig)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		evt  map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "should keep default fields",
+			evt: map[string]interface{}{
+				"eventTime":         "2021-06-30T00:00:00Z",
+				"eventName":         "Decrypt",
+				"eventSource":       "kms.amazonaws.com",
+				"userIdentity":      map[string]interface{}{"type": "AssumedRole", "arn": "arn:aws:sts::123456789012:assumed-role/ci/session", "accountId": "123456789012"},
+				"requestParameters": map[string]interface{}{"keyId": "alias/app"},
+			},
+			want: map[string]interface{}{
+				"eventTime":    "2021-06-30T00:00:00Z",
+				"eventName":    "Decrypt",
+				"eventSource":  "kms.amazonaws.com",
+				"userIdentity": map[string]interface{}{"type": "AssumedRole", "arn": "arn:aws:sts::123456789012:assumed-role/ci/session"},
+			},
+		},
+		{
+			name: "should keep event source fields",
+			evt: map[string]interface{}{
+				"eventTime":         "2021-06-30T00:00:00Z",
+				"eventName":         "GetObject",
+				"eventSource":       "s3.amazonaws.com",
+				"userIdentity":      map[string]interface{}{"type": "AssumedRole"},
+				"requestParameters": map[string]interface{}{"bucketName": "testbucket", "key": "test"},
+				"resources": []interface{}{
+					map[string]interface{}{"ARN": "arn:aws:s3:::testbucket", "type": "AWS::S3::Bucket"},
+					map[string]interface{}{"ARN": "arn:aws:s3:::testbucket/test", "type": "AWS::S3::Object"},
+				},
+			},
+			want: map[string]interface{}{
+				"eventTime":         "2021-06-30T00:00:00Z",
+				"eventName":         "GetObject",
+				"eventSource":       "s3.amazonaws.com",
+				"requestParameters": map[string]interface{}{"bucketName": "testbucket"},
+				"resources":         []interface{}{nil, map[string]interface{}{"ARN": "arn:aws:s3:::testbucket/test"}},
+			},
+		},
+		{
+			name: "should skip missing fields",
+			evt:  map[string]interface{}{"eventName": "ConsoleLogin", "x_tags": []interface{}{"root"}},
+			want: map[string]interface{}{"eventName": "ConsoleLogin", "x_tags": []interface{}{"root"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rs.Project(tt.evt)
+			require.True(t, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRuleSet_ProjectNotConfigured(t *testing.T) {
+	rs, err := LoadAndValidate(yamlBenchConfig)
+	require.NoError(t, err)
+
+	evt := map[string]interface{}{"eventName": "ConsoleLogin", "awsRegion": "us-east-1"}
+
+	got, ok := rs.Project(evt)
+	require.False(t, ok)
+	require.Equal(t, evt, got)
+}
+
+func TestValidateProjection(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := Load(`
+version: 2
+rules: []
+projection:
+  fields: []
+  event_sources:
+    s3.amazonaws.com:
+    - resources[a]
+`)
+	assert.NoError(err)
+	assert.EqualError(cfg.Validate(), `line 5: projection.fields: must not be empty; `+
+		`line 8: projection.event_sources[s3.amazonaws.com][0]: invalid field path: "resources[a]" has an invalid index "a"`)
+}
+
+func TestMerge_Projection(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := Merge([]*Document{
+		{Source: "a.yaml", Content: yamlProjectionConfig},
+		{Source: "b.yaml", Content: "version: 2\nrules: []\nprojection:\n  fields: [eventName]\n"},
+	})
+	assert.EqualError(err, `b.yaml: line 4: projection: already configured in a.yaml`)
+}
@@ -2,118 +2,368 @@ package rules
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/wolfeidau/ssmcache"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/slice"
 )
 
-// Configuration configuration containing our rules which are used to filter events
+// Configuration configuration containing our rules which are used to filter events, along with the
+// default action applied to records which don't match a drop or keep rule, the named patterns and
+// lists referenced by the rules, along with the transforms, projection and enrichment applied to retained records
 type Configuration struct {
-	Rules []*Rule `yaml:"rules" validate:"required,dive"`
+	Version       int               `yaml:"version" validate:"required,oneof=2"`
+	DefaultAction string            `yaml:"default_action,omitempty" validate:"omitempty,oneof=drop keep"`
+	Patterns      map[string]string `yaml:"patterns,omitempty" validate:"omitempty,dive,required,is-regex"`
+	Lists         map[string]*List  `yaml:"lists,omitempty" validate:"omitempty,dive,required"`
+	Rules         []*Rule           `yaml:"rules" validate:"required,dive,required"`
+	Transforms    []*Transform      `yaml:"transforms,omitempty" validate:"omitempty,dive,required"`
+	Projection    *Projection       `yaml:"projection,omitempty"`
+	Enrichment    *Enrichment       `yaml:"enrichment,omitempty"`
+
+	// node the parsed YAML document, used to locate validation errors, along with the source it was
+	// loaded from when merging multiple documents
+	node   *yaml.Node
+	source string
+
+	// origins the documents patterns, lists, the projection and enrichment were loaded from when merging multiple documents
+	origins map[string]*origin
+}
+
+// DefaultActionOrKeep return the default action, records are kept unless configured otherwise
+func (cr *Configuration) DefaultActionOrKeep() string {
+	if cr.DefaultAction == "" {
+		return ActionKeep
+	}
+
+	return cr.DefaultAction
 }
 
-// Validate validate the configuration rules
+// Validate validate the configuration rules, returning ValidationErrors describing each problem found
 func (cr *Configuration) Validate() error {
 	validate := validator.New()
 
+	// use the YAML field names so errors can be related back to the document
+	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		return strings.SplitN(fld.Tag.Get("yaml"), ",", 2)[0]
+	})
+
 	err := validate.RegisterValidation("is-regex", ValidateIsRegex)
 	if err != nil {
 		return err
 	}
 
-	return validate.Struct(cr)
-}
+	err = validate.RegisterValidation("field-path", ValidateIsFieldPath)
+	if err != nil {
+		return err
+	}
 
-// EvalRules iterate over all rules and return a match if one evaluates to true
-func (cr *Configuration) EvalRules(evt map[string]interface{}) (bool, error) {
-	for _, rule := range cr.Rules {
-		match, err := rule.Eval(evt)
-		if err != nil {
-			return false, err
-		}
-		if match {
-			return true, nil
+	err = validate.RegisterValidation("operator", ValidateIsOperator)
+	if err != nil {
+		return err
+	}
+
+	err = validate.RegisterValidation("cel", ValidateIsExpression)
+	if err != nil {
+		return err
+	}
+
+	err = validate.RegisterValidation("s3-url", ValidateIsS3URL)
+	if err != nil {
+		return err
+	}
+
+	validate.RegisterStructValidation(ValidateConfiguration, Configuration{})
+	validate.RegisterStructValidation(ValidateRule, Rule{})
+	validate.RegisterStructValidation(ValidateMatch, Match{})
+	validate.RegisterStructValidation(ValidateCondition, Condition{})
+	validate.RegisterStructValidation(ValidateList, List{})
+	validate.RegisterStructValidation(ValidateTable, Table{})
+	validate.RegisterStructValidation(ValidateFieldTransform, FieldTransform{})
+
+	err = validate.Struct(cr)
+	if err != nil {
+		var verrs validator.ValidationErrors
+		if errors.As(err, &verrs) {
+			return cr.translateErrors(verrs)
 		}
+
+		return err
 	}
 
-	return false, nil
+	return nil
 }
 
-// Load load the configuration from the provided string
+// Load load the configuration from the provided string, unknown fields are rejected and documents using
+// an older version of the schema are upgraded to the latest version
 func Load(rawCfg string) (*Configuration, error) {
-	ctr := new(Configuration)
+	node := new(yaml.Node)
 
-	err := yaml.Unmarshal([]byte(rawCfg), ctr)
+	err := yaml.Unmarshal([]byte(rawCfg), node)
 	if err != nil {
 		return nil, err
 	}
 
-	return ctr, nil
-}
-
-// LoadFromSSMAndValidate load the configuration from ssmcache and validate it
-func LoadFromSSMAndValidate(ctx context.Context, ssm ssmcache.Cache, path string) (*Configuration, error) {
-	log.Ctx(ctx).Info().Str("path", path).Msg("loading config from ssmcache")
+	version, err := documentVersion(node)
+	if err != nil {
+		return nil, err
+	}
 
-	rawCfg, err := ssm.GetKey(path, false) // config is not encrypted
+	ctr, err := decodeVersion(rawCfg, version)
 	if err != nil {
-		return nil, fmt.Errorf("read config from ssm failed: %w", err)
+		return nil, err
 	}
 
+	ctr.Version = CurrentVersion
+	ctr.node = node
+
+	return ctr, nil
+}
+
+// LoadAndValidate load the configuration from the provided string, validate it, compile it into a rule set
+// and run the tests embedded in the rules
+func LoadAndValidate(rawCfg string) (*RuleSet, error) {
 	rulesCfg, err := Load(rawCfg)
 	if err != nil {
 		return nil, fmt.Errorf("load rules configuration failed: %w", err)
 	}
 
-	err = rulesCfg.Validate()
+	return validateAndCompile(rulesCfg)
+}
+
+// validateAndCompile validate the configuration, compile it into a rule set and run the tests embedded in the rules
+func validateAndCompile(rulesCfg *Configuration) (*RuleSet, error) {
+	err := rulesCfg.Validate()
 	if err != nil {
 		return nil, fmt.Errorf("rules validation failed: %w", err)
 	}
 
-	return rulesCfg, nil
+	rs, err := Compile(rulesCfg)
+	if err != nil {
+		return nil, fmt.Errorf("rules compile failed: %w", err)
+	}
+
+	err = rs.RunTests()
+	if err != nil {
+		return nil, fmt.Errorf("rules tests failed: %w", err)
+	}
+
+	return rs, nil
+}
+
+// LoadFromSSMAndValidate load the configuration from ssmcache, validate it and compile it into a rule set
+func LoadFromSSMAndValidate(ctx context.Context, ssm ssmcache.Cache, path string) (*RuleSet, error) {
+	return LoadFromSourceAndValidate(ctx, NewSSMSource(ssm, path))
+}
+
+// LoadFromSourceAndValidate load the configuration documents from the source, merge them, validate the result
+// and compile it into a rule set
+func LoadFromSourceAndValidate(ctx context.Context, src ConfigSource) (*RuleSet, error) {
+	docs, err := src.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Info().Strs("sources", documentSources(docs)).Msg("loading config")
+
+	return MergeAndValidate(docs)
 }
 
-// Rule rule with a name, and one or more matches
+// Rule rule with a name, an action and a condition built from one or more matches, nested all, any
+// and not groups, and an optional CEL expression which must also evaluate to true. The description,
+// owner and ticket record why the rule exists and who approved it, these are included in logs.
 type Rule struct {
-	Name    string   `yaml:"name" validate:"required"`
-	Matches []*Match `yaml:"matches" validate:"required,dive"`
+	Name        string  `yaml:"name" validate:"required"`
+	Description string  `yaml:"description,omitempty"`
+	Owner       string  `yaml:"owner,omitempty"`
+	Ticket      string  `yaml:"ticket,omitempty"`
+	Expires     string  `yaml:"expires,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	Enabled     *bool   `yaml:"enabled,omitempty"`
+	Action      string  `yaml:"action,omitempty" validate:"omitempty,oneof=drop keep tag sample"`
+	SampleRate  float64 `yaml:"sample_rate,omitempty" validate:"omitempty,gt=0,lte=1"`
+	Mode        string  `yaml:"mode,omitempty" validate:"omitempty,oneof=enforce dry_run"`
+	Condition   `yaml:",inline"`
+	When        string      `yaml:"when,omitempty" validate:"omitempty,cel"`
+	Tests       []*RuleTest `yaml:"tests,omitempty" validate:"omitempty,dive,required"`
+
+	// Source where the rule was loaded from when merging multiple documents
+	Source string `yaml:"-"`
+
+	// node and index locate the rule in the YAML document it was loaded from when merging multiple documents
+	node  *yaml.Node
+	index int
 }
 
-// Match match containing the field to be checked and the REGEX used to match
+// ruleConditionFields the fields of a rule which make up its condition, at least one must be provided
+var ruleConditionFields = append(conditionFields, "when")
+
+// ActionOrDrop return the action for the rule, a match drops the record unless configured otherwise
+func (mc *Rule) ActionOrDrop() string {
+	if mc.Action == "" {
+		return ActionDrop
+	}
+
+	return mc.Action
+}
+
+// Modes a rule can be evaluated in, a rule in dry run mode records the records it matches without changing
+// their action, so a new rule can be reviewed before it is enforced
+const (
+	ModeEnforce = "enforce"
+	ModeDryRun  = "dry_run"
+)
+
+// IsDryRun return true if the rule is in dry run mode, rules are enforced unless configured otherwise
+func (mc *Rule) IsDryRun() bool {
+	return mc.Mode == ModeDryRun
+}
+
+// ExpiresLayout the layout of the expires date of a rule
+const ExpiresLayout = "2006-01-02"
+
+// Status of a rule at a point in time
+const (
+	StatusEnabled  = "enabled"
+	StatusDisabled = "disabled"
+	StatusExpired  = "expired"
+)
+
+// IsEnabled return false if the rule has been disabled, rules are enabled unless configured otherwise
+func (mc *Rule) IsEnabled() bool {
+	return mc.Enabled == nil || *mc.Enabled
+}
+
+// IsExpired return true if the expiry date of the rule has passed, rules apply until the end of the
+// expiry date in UTC
+func (mc *Rule) IsExpired(t time.Time) bool {
+	expiry, ok := mc.expiry()
+
+	return ok && !t.Before(expiry)
+}
+
+// Status return whether the rule is enabled, disabled or expired at the time
+func (mc *Rule) Status(t time.Time) string {
+	switch {
+	case !mc.IsEnabled():
+		return StatusDisabled
+	case mc.IsExpired(t):
+		return StatusExpired
+	default:
+		return StatusEnabled
+	}
+}
+
+// expiry return the time the rule expires, which is the end of the expiry date
+func (mc *Rule) expiry() (time.Time, bool) {
+	if mc.Expires == "" {
+		return time.Time{}, false
+	}
+
+	date, err := time.Parse(ExpiresLayout, mc.Expires)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return date.AddDate(0, 0, 1), true
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler, adding the name and metadata of the rule to log entries
+func (mc *Rule) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("name", mc.Name).Str("action", mc.ActionOrDrop())
+
+	if mc.Action == ActionSample {
+		e.Float64("sample_rate", mc.SampleRate)
+	}
+
+	if mc.IsDryRun() {
+		e.Str("mode", mc.Mode)
+	}
+
+	fields := [][2]string{
+		{"description", mc.Description},
+		{"owner", mc.Owner},
+		{"ticket", mc.Ticket},
+		{"expires", mc.Expires},
+		{"source", mc.Source},
+	}
+
+	for _, fld := range fields {
+		if fld[1] != "" {
+			e.Str(fld[0], fld[1])
+		}
+	}
+}
+
+// Match match containing the field path to be checked and the operator used to match,
+// the operator defaults to regex for backwards compatibility. The regex and values operands can
+// instead reference a named pattern or list in the configuration using pattern and in_list.
 type Match struct {
-	FieldName string `yaml:"field_name" validate:"required,oneof=eventName eventSource awsRegion recipientAccountId"`
-	Regex     string `yaml:"regex" validate:"is-regex"`
-}
-
-// Eval evaluate the match for a given event, this will run each field check in the rule
-// if ALL evaluate to true
-func (mc *Rule) Eval(evt map[string]interface{}) (bool, error) {
-	b := true
-
-	for k, v := range evt {
-		for _, mtch := range mc.Matches {
-			if mtch.FieldName == k {
-				// if the value is not a string skip the matching
-				vs, ok := v.(string)
-				if !ok {
-					continue
-				}
-
-				mt, err := regexp.MatchString(mtch.Regex, vs)
-				if err != nil {
-					return false, err
-				}
-
-				b = b && mt
-			}
+	FieldName string   `yaml:"field_name" validate:"required,field-path"`
+	Op        string   `yaml:"op,omitempty" validate:"omitempty,operator"`
+	Regex     string   `yaml:"regex,omitempty" validate:"is-regex"`
+	Pattern   string   `yaml:"pattern,omitempty"`
+	Value     Scalar   `yaml:"value,omitempty"`
+	Values    []Scalar `yaml:"values,omitempty"`
+	InList    string   `yaml:"in_list,omitempty"`
+	OnMissing string   `yaml:"on_missing,omitempty" validate:"omitempty,oneof=no_match match error"`
+
+	// pattern and list resolved from the configuration when compiled
+	pattern string
+	list    *List
+}
+
+// ValidateConfiguration implements validator.StructLevelFunc, ensuring rule names are unique as rules and their
+// tests refer to each other by name
+func ValidateConfiguration(sl validator.StructLevel) {
+	cfg, ok := sl.Current().Interface().(Configuration)
+	if !ok {
+		return
+	}
+
+	names := make(map[string]int)
+
+	for i, rule := range cfg.Rules {
+		// null and unnamed rules are reported by the required tags
+		if rule == nil || rule.Name == "" {
+			continue
 		}
+
+		if prev, ok := names[rule.Name]; ok {
+			sl.ReportError(rule.Name, fmt.Sprintf("rules[%d].name", i), "Name", "unique", fmt.Sprintf("rules[%d]", prev))
+			continue
+		}
+
+		names[rule.Name] = i
+	}
+}
+
+// ValidateRule implements validator.StructLevelFunc, ensuring every rule has something to evaluate
+func ValidateRule(sl validator.StructLevel) {
+	rule, ok := sl.Current().Interface().(Rule)
+	if !ok {
+		return
+	}
+
+	if rule.IsEmpty() && rule.When == "" {
+		sl.ReportError(rule.Matches, "matches", "Matches", "required_without_all", strings.Join(ruleConditionFields[1:], " "))
 	}
 
-	return b, nil
+	switch {
+	case rule.Action == ActionSample && rule.SampleRate == 0:
+		sl.ReportError(rule.SampleRate, "sample_rate", "SampleRate", "required_if", "action "+ActionSample)
+	case rule.Action != ActionSample && rule.SampleRate != 0:
+		sl.ReportError(rule.SampleRate, "sample_rate", "SampleRate", "excluded_unless", "action "+ActionSample)
+	}
 }
 
 // ValidateIsRegex implements validator.Func
@@ -121,3 +371,14 @@ func ValidateIsRegex(fl validator.FieldLevel) bool {
 	_, err := regexp.Compile(fl.Field().String())
 	return err == nil
 }
+
+// ValidateIsOperator implements validator.Func
+func ValidateIsOperator(fl validator.FieldLevel) bool {
+	return slice.ContainsString(operators, fl.Field().String())
+}
+
+// ValidateIsFieldPath implements validator.Func
+func ValidateIsFieldPath(fl validator.FieldLevel) bool {
+	_, err := ParsePath(fl.Field().String())
+	return err == nil
+}
@@ -31,19 +31,103 @@ func TestRules(t *testing.T) {
 	err = ctr.Validate()
 	assert.NoError(err)
 
-	match, err := ctr.Rules[0].Eval(map[string]interface{}{
+	match, err := evalCondition(&ctr.Rules[0].Condition, map[string]interface{}{
 		"eventName":   "Encrypt",
 		"eventSource": "kms.amazonaws.com",
 	})
 	assert.NoError(err)
 	assert.True(match)
 
-	match, err = ctr.Rules[0].Eval(map[string]interface{}{
+	match, err = evalCondition(&ctr.Rules[0].Condition, map[string]interface{}{
 		"eventName":   "Encrypt",
 		"eventSource": "logs.amazonaws.com",
 	})
 	assert.NoError(err)
 	assert.False(match)
+
+	// records missing the fields in a rule should not match
+	match, err = evalCondition(&ctr.Rules[0].Condition, map[string]interface{}{
+		"awsRegion": "us-east-1",
+	})
+	assert.NoError(err)
+	assert.False(match)
+}
+
+var yamlNestedConfig = `
+---
+rules:
+  - name: check_ci_uploads
+    matches:
+    - field_name: userIdentity.sessionContext.sessionIssuer.userName
+      regex: "^ci-"
+    - field_name: resources[0].ARN
+      regex: "^arn:aws:s3:::testbucket"
+`
+
+func TestRulesNestedFields(t *testing.T) {
+	assert := require.New(t)
+
+	ctr, err := Load(yamlNestedConfig)
+	assert.NoError(err)
+
+	err = ctr.Validate()
+	assert.NoError(err)
+
+	match, err := evalCondition(&ctr.Rules[0].Condition, pathEvent)
+	assert.NoError(err)
+	assert.True(match)
+
+	match, err = evalCondition(&ctr.Rules[0].Condition, map[string]interface{}{
+		"userIdentity": map[string]interface{}{
+			"sessionContext": map[string]interface{}{
+				"sessionIssuer": map[string]interface{}{"userName": "admin"},
+			},
+		},
+		"resources": []interface{}{
+			map[string]interface{}{"ARN": "arn:aws:s3:::testbucket"},
+		},
+	})
+	assert.NoError(err)
+	assert.False(match)
+}
+
+func TestValidateFieldPath(t *testing.T) {
+	assert := require.New(t)
+
+	ctr, err := Load(`
+rules:
+  - name: bad_path
+    matches:
+    - field_name: resources[x].ARN
+      regex: ".*"
+`)
+	assert.NoError(err)
+
+	err = ctr.Validate()
+	assert.Error(err)
+	assert.EqualError(err, `line 5: rules[0].matches[0].field_name in rule "bad_path": invalid field path: "resources[x].ARN" has an invalid index "x"`)
+}
+
+func TestLoadAndValidate_DuplicateName(t *testing.T) {
+	_, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: kms
+    when: record.eventSource == "kms.amazonaws.com"
+  - name: kms
+    when: record.eventSource == "sts.amazonaws.com"
+`)
+	require.EqualError(t, err, `rules validation failed: line 6: rules[1].name in rule "kms": duplicate rule name, also used by rules[0]`)
+}
+
+func TestLoadAndValidate_NullRule(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := LoadAndValidate("version: 2\nrules: [~]\n")
+	assert.EqualError(err, `rules validation failed: line 2: rules[0]: is required`)
+
+	_, err = LoadAndValidate("rules:\n  - ~\n")
+	assert.EqualError(err, `rules validation failed: line 2: rules[0]: is required`)
 }
 
 func TestLoadFromSSMAndValidate(t *testing.T) {
@@ -56,20 +140,27 @@ func TestLoadFromSSMAndValidate(t *testing.T) {
 
 	ssm.EXPECT().GetKey("/config/whatever", false).Return(yamlConfig, nil)
 
-	rulesCfg, err := LoadFromSSMAndValidate(context.TODO(), ssm, "/config/whatever")
+	rs, err := LoadFromSSMAndValidate(context.TODO(), ssm, "/config/whatever")
 	assert.NoError(err)
 
-	assert.Equal(&Configuration{Rules: []*Rule{{
-		Name: "check_kms",
+	// the unversioned document is upgraded to the latest schema
+	rules := rs.Configuration().Rules
+	assert.Len(rules, 1)
+	assert.Equal("check_kms", rules[0].Name)
+	assert.Equal(ActionDrop, rules[0].Action)
+	assert.Equal("/config/whatever", rules[0].Source)
+	assert.Equal(Condition{
 		Matches: []*Match{
 			{
 				FieldName: "eventName",
+				Op:        OpRegex,
 				Regex:     ".*crypt",
 			},
 			{
 				FieldName: "eventSource",
+				Op:        OpRegex,
 				Regex:     "kms.*",
 			},
 		},
-	}}}, rulesCfg)
+	}, rules[0].Condition)
 }
@@ -0,0 +1,248 @@
+package rules
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// now the current time, used to disable rules which have expired
+var now = time.Now
+
+// RuleSet immutable set of rules compiled from a validated configuration, with regexes compiled
+// and field paths parsed once so they can be evaluated efficiently against each record. Rules which
+// are disabled, or have expired when compiled, are skipped.
+type RuleSet struct {
+	cfg           *Configuration
+	defaultAction string
+	rules         []*compiledRule
+	transforms    []*compiledTransform
+	projection    *compiledProjection
+	enrichment    *compiledEnrichment
+	byName        map[string]*Rule
+	compiledAt    time.Time
+	expired       []*Rule
+	nextExpiry    time.Time
+}
+
+type compiledRule struct {
+	name       string
+	action     string
+	sampleRate float64
+	dryRun     bool
+	cond       *compiledCondition
+	when       cel.Program
+}
+
+// eval evaluate the rule condition and expression against the record
+func (cr *compiledRule) eval(evt map[string]interface{}) (bool, error) {
+	match, err := cr.cond.eval(evt)
+	if err != nil || !match {
+		return false, err
+	}
+
+	if cr.when != nil {
+		return evalExpression(cr.when, evt)
+	}
+
+	return true, nil
+}
+
+// Actions which can be taken for a record
+const (
+	ActionDrop   = "drop"
+	ActionKeep   = "keep"
+	ActionTag    = "tag"
+	ActionSample = "sample"
+)
+
+// Decision the outcome of evaluating a record against the rule set
+type Decision struct {
+	// Action either keep or drop
+	Action string
+	// Rule the name of the rule which decided the action, empty if the default action was applied
+	Rule string
+	// Tags the names of any tag rules which matched the record
+	Tags []string
+	// DryRun the names of any rules in dry run mode which matched the record, these don't change the action
+	DryRun []string
+	// SampleRate the fraction of matching records kept when the action was decided by a sample rule
+	SampleRate float64
+	// ExpressionErrors the rules which were skipped as their when expression failed to evaluate
+	ExpressionErrors ExpressionErrors
+}
+
+// Compile compile a validated configuration into a rule set
+func Compile(cfg *Configuration) (*RuleSet, error) {
+	rs := &RuleSet{
+		cfg:           cfg,
+		defaultAction: cfg.DefaultActionOrKeep(),
+		byName:        make(map[string]*Rule),
+		compiledAt:    now(),
+	}
+
+	err := cfg.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range cfg.Rules {
+		if _, ok := rs.byName[rule.Name]; !ok {
+			rs.byName[rule.Name] = rule
+		}
+
+		switch rule.Status(rs.compiledAt) {
+		case StatusDisabled:
+			continue
+		case StatusExpired:
+			rs.expired = append(rs.expired, rule)
+			continue
+		}
+
+		if expiry, ok := rule.expiry(); ok && (rs.nextExpiry.IsZero() || expiry.Before(rs.nextExpiry)) {
+			rs.nextExpiry = expiry
+		}
+
+		cc, err := compileCondition(&rule.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+
+		cr := &compiledRule{name: rule.Name, action: rule.ActionOrDrop(), sampleRate: rule.SampleRate, dryRun: rule.IsDryRun(), cond: cc}
+
+		if rule.When != "" {
+			cr.when, err = CompileExpression(rule.When)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: failed to compile when expression: %w", rule.Name, err)
+			}
+		}
+
+		rs.rules = append(rs.rules, cr)
+	}
+
+	for _, tr := range cfg.Transforms {
+		ct, err := compileTransform(tr)
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %w", tr.Name, err)
+		}
+
+		rs.transforms = append(rs.transforms, ct)
+	}
+
+	if cfg.Projection != nil {
+		rs.projection, err = compileProjection(cfg.Projection)
+		if err != nil {
+			return nil, fmt.Errorf("projection: %w", err)
+		}
+	}
+
+	if cfg.Enrichment != nil {
+		rs.enrichment, err = compileEnrichment(cfg.Enrichment)
+		if err != nil {
+			return nil, fmt.Errorf("enrichment: %w", err)
+		}
+	}
+
+	return rs, nil
+}
+
+// Configuration return the configuration the rule set was compiled from
+func (rs *RuleSet) Configuration() *Configuration {
+	return rs.cfg
+}
+
+// Rule return the rule with the name, this is used to add the metadata of the rule which decided a record to logs
+func (rs *RuleSet) Rule(name string) *Rule {
+	return rs.byName[name]
+}
+
+// Expired return the rules which were skipped as they had expired when the rule set was compiled
+func (rs *RuleSet) Expired() []*Rule {
+	return rs.expired
+}
+
+// isActive return true if the rule was compiled into the rule set
+func (rs *RuleSet) isActive(rule *Rule) bool {
+	return rule.Status(rs.compiledAt) == StatusEnabled
+}
+
+// expiresBy return true if a rule in the rule set has expired by the time, so it should be compiled again
+func (rs *RuleSet) expiresBy(t time.Time) bool {
+	return !rs.nextExpiry.IsZero() && !t.Before(rs.nextExpiry)
+}
+
+// Evaluate iterate over the rules in order and return the decision for the record, the first drop, keep or
+// sample rule which matches decides the action, tag rules which match are recorded and evaluation continues. If
+// no drop, keep or sample rule matches the record is kept when tagged, otherwise the default action is applied.
+// Rules in dry run mode which match are recorded and evaluation continues as if they didn't match. Rules with
+// a when expression which fails to evaluate don't match and the error is recorded in the decision.
+func (rs *RuleSet) Evaluate(evt map[string]interface{}) (*Decision, error) {
+	return rs.evaluate(evt, "")
+}
+
+// evaluate evaluate the record, the named rule is enforced even if it is in dry run mode, this is used to run
+// the tests of a rule in dry run mode against the outcome it will have once it is enforced
+func (rs *RuleSet) evaluate(evt map[string]interface{}, enforce string) (*Decision, error) {
+	dec := new(Decision)
+
+	for _, rule := range rs.rules {
+		match, err := rule.eval(evt)
+		if err != nil {
+			var exprErr *expressionError
+			if !errors.As(err, &exprErr) {
+				return nil, fmt.Errorf("rule %s: %w", rule.name, err)
+			}
+
+			dec.ExpressionErrors.add(rule.name, exprErr)
+		}
+		if !match {
+			continue
+		}
+
+		if rule.dryRun && rule.name != enforce {
+			dec.DryRun = append(dec.DryRun, rule.name)
+			continue
+		}
+
+		if rule.action == ActionTag {
+			dec.Tags = append(dec.Tags, rule.name)
+			continue
+		}
+
+		dec.Action, dec.Rule = rule.action, rule.name
+
+		if rule.action == ActionSample {
+			dec.Action, dec.SampleRate = ActionDrop, rule.sampleRate
+			if sampled(evt, rule.sampleRate) {
+				dec.Action = ActionKeep
+			}
+		}
+
+		return dec, nil
+	}
+
+	dec.Action = rs.defaultAction
+	if len(dec.Tags) > 0 {
+		dec.Action = ActionKeep
+	}
+
+	return dec, nil
+}
+
+// sampled return true if the record is in the fraction kept by a sample rule, records are chosen by hashing
+// the eventID so processing the same records again keeps the same ones. Records without an eventID are kept.
+func sampled(evt map[string]interface{}, rate float64) bool {
+	id, ok := evt["eventID"].(string)
+	if !ok || id == "" {
+		return true
+	}
+
+	sum := sha256.Sum256([]byte(id))
+
+	// use the top 53 bits so the fraction is exact in a float64
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11)/(1<<53) < rate
+}
@@ -0,0 +1,329 @@
+package rules
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var yamlBenchConfig = `
+---
+version: 2
+rules:
+  - name: check_kms
+    matches:
+    - field_name: eventName
+      regex: ".*crypt"
+    - field_name: eventSource
+      regex: "kms.*"
+  - name: ci_uploads
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: s3.amazonaws.com
+    - field_name: userIdentity.sessionContext.sessionIssuer.userName
+      op: prefix
+      value: ci-
+  - name: internal_describe
+    matches:
+    - field_name: eventName
+      op: glob
+      value: "Describe*"
+    - field_name: sourceIPAddress
+      op: cidr
+      values: [10.0.0.0/8, 172.16.0.0/12]
+`
+
+func TestRuleSet_Evaluate(t *testing.T) {
+	assert := require.New(t)
+
+	rs, err := LoadAndValidate(yamlBenchConfig)
+	assert.NoError(err)
+
+	dec, err := rs.Evaluate(map[string]interface{}{
+		"eventName":       "DescribeInstances",
+		"eventSource":     "ec2.amazonaws.com",
+		"sourceIPAddress": "10.0.0.1",
+	})
+	assert.NoError(err)
+	assert.Equal(&Decision{Action: ActionDrop, Rule: "internal_describe"}, dec)
+
+	dec, err = rs.Evaluate(map[string]interface{}{
+		"eventName":       "DescribeInstances",
+		"eventSource":     "ec2.amazonaws.com",
+		"sourceIPAddress": "8.8.8.8",
+	})
+	assert.NoError(err)
+	assert.Equal(&Decision{Action: ActionKeep}, dec)
+}
+
+var yamlActionConfig = `
+---
+version: 2
+default_action: drop
+rules:
+  - name: tag_root
+    action: tag
+    matches:
+    - field_name: userIdentity.type
+      op: equals
+      value: Root
+  - name: drop_kms_reads
+    action: drop
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    - field_name: readOnly
+      op: equals
+      value: "true"
+  - name: keep_iam_kms
+    action: keep
+    matches:
+    - field_name: eventSource
+      op: in
+      values: [iam.amazonaws.com, kms.amazonaws.com]
+`
+
+func TestRuleSet_EvaluateActions(t *testing.T) {
+	rs, err := LoadAndValidate(yamlActionConfig)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		evt  map[string]interface{}
+		want *Decision
+	}{
+		{
+			name: "should keep allowed event source",
+			evt:  map[string]interface{}{"eventSource": "iam.amazonaws.com"},
+			want: &Decision{Action: ActionKeep, Rule: "keep_iam_kms"},
+		},
+		{
+			name: "should drop with earlier drop rule",
+			evt:  map[string]interface{}{"eventSource": "kms.amazonaws.com", "readOnly": "true"},
+			want: &Decision{Action: ActionDrop, Rule: "drop_kms_reads"},
+		},
+		{
+			name: "should apply default action to unmatched",
+			evt:  map[string]interface{}{"eventSource": "s3.amazonaws.com"},
+			want: &Decision{Action: ActionDrop},
+		},
+		{
+			name: "should keep tagged record which is otherwise unmatched",
+			evt:  map[string]interface{}{"eventSource": "s3.amazonaws.com", "userIdentity": map[string]interface{}{"type": "Root"}},
+			want: &Decision{Action: ActionKeep, Tags: []string{"tag_root"}},
+		},
+		{
+			name: "should tag record and continue evaluation",
+			evt:  map[string]interface{}{"eventSource": "iam.amazonaws.com", "userIdentity": map[string]interface{}{"type": "Root"}},
+			want: &Decision{Action: ActionKeep, Rule: "keep_iam_kms", Tags: []string{"tag_root"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := rs.Evaluate(tt.evt)
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestRuleSet_EvaluateDryRun(t *testing.T) {
+	rs, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: drop_describe
+    mode: dry_run
+    matches:
+    - field_name: eventName
+      op: prefix
+      value: Describe
+  - name: drop_kms
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+  - name: keep_ec2
+    action: keep
+    mode: enforce
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: ec2.amazonaws.com
+`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		evt  map[string]interface{}
+		want *Decision
+	}{
+		{
+			name: "should keep record matched by dry run rule",
+			evt:  map[string]interface{}{"eventSource": "s3.amazonaws.com", "eventName": "DescribeBucket"},
+			want: &Decision{Action: ActionKeep, DryRun: []string{"drop_describe"}},
+		},
+		{
+			name: "should continue evaluation after dry run rule",
+			evt:  map[string]interface{}{"eventSource": "kms.amazonaws.com", "eventName": "DescribeKey"},
+			want: &Decision{Action: ActionDrop, Rule: "drop_kms", DryRun: []string{"drop_describe"}},
+		},
+		{
+			name: "should enforce other rules",
+			evt:  map[string]interface{}{"eventSource": "ec2.amazonaws.com", "eventName": "RunInstances"},
+			want: &Decision{Action: ActionKeep, Rule: "keep_ec2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := rs.Evaluate(tt.evt)
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestRuleSet_EvaluateSample(t *testing.T) {
+	assert := require.New(t)
+
+	rs, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: sample_assume_role
+    action: sample
+    sample_rate: 0.25
+    matches:
+    - field_name: eventName
+      op: equals
+      value: AssumeRole
+`)
+	assert.NoError(err)
+
+	kept := 0
+
+	for i := 0; i < 10000; i++ {
+		evt := map[string]interface{}{"eventID": fmt.Sprintf("id-%d", i), "eventName": "AssumeRole"}
+
+		got, err := rs.Evaluate(evt)
+		assert.NoError(err)
+		assert.Equal("sample_assume_role", got.Rule)
+		assert.Equal(0.25, got.SampleRate)
+
+		if got.Action == ActionKeep {
+			kept++
+		}
+
+		// the same record is always sampled the same way
+		again, err := rs.Evaluate(evt)
+		assert.NoError(err)
+		assert.Equal(got, again)
+	}
+
+	assert.InDelta(2500, kept, 150)
+
+	got, err := rs.Evaluate(map[string]interface{}{"eventName": "AssumeRole"})
+	assert.NoError(err)
+	assert.Equal(&Decision{Action: ActionKeep, Rule: "sample_assume_role", SampleRate: 0.25}, got)
+
+	got, err = rs.Evaluate(map[string]interface{}{"eventID": "id-1", "eventName": "GetObject"})
+	assert.NoError(err)
+	assert.Equal(&Decision{Action: ActionKeep}, got)
+}
+
+func TestValidateSampleRate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want string
+	}{
+		{
+			name: "should require rate for sample action",
+			cfg:  "version: 2\nrules:\n  - name: a\n    action: sample\n    when: has(record.eventName)\n",
+			want: `line 3: rules[0].sample_rate in rule "a": is required when action is sample`,
+		},
+		{
+			name: "should reject rate above one",
+			cfg:  "version: 2\nrules:\n  - name: a\n    action: sample\n    sample_rate: 1.5\n    when: has(record.eventName)\n",
+			want: `line 5: rules[0].sample_rate in rule "a": 1.5 must be at most 1`,
+		},
+		{
+			name: "should reject rate for other actions",
+			cfg:  "version: 2\nrules:\n  - name: a\n    action: keep\n    sample_rate: 0.5\n    when: has(record.eventName)\n",
+			want: `line 5: rules[0].sample_rate in rule "a": can only be used when action is sample`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			cfg, err := Load(tt.cfg)
+			assert.NoError(err)
+			assert.EqualError(cfg.Validate(), tt.want)
+		})
+	}
+}
+
+func TestRuleSet_EvaluateError(t *testing.T) {
+	assert := require.New(t)
+
+	rs, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: strict_account
+    matches:
+    - field_name: recipientAccountId
+      op: equals
+      value: "123456789012"
+      on_missing: error
+`)
+	assert.NoError(err)
+
+	_, err = rs.Evaluate(map[string]interface{}{"eventName": "Decrypt"})
+	assert.ErrorIs(err, ErrFieldMissing)
+	assert.Contains(err.Error(), "strict_account")
+}
+
+func BenchmarkRuleSet_Evaluate(b *testing.B) {
+	rs, err := LoadAndValidate(yamlBenchConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	records := benchRecords(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// each op evaluates a single record, so ns/op is the per record cost
+	for i := 0; i < b.N; i++ {
+		_, err := rs.Evaluate(records[i%len(records)])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchRecords(n int) []map[string]interface{} {
+	records := make([]map[string]interface{}, n)
+
+	for i := range records {
+		records[i] = map[string]interface{}{
+			"eventName":       fmt.Sprintf("Describe%d", i%7),
+			"eventSource":     []string{"ec2.amazonaws.com", "s3.amazonaws.com", "kms.amazonaws.com"}[i%3],
+			"sourceIPAddress": fmt.Sprintf("10.0.%d.%d", i%255, i%253),
+			"userIdentity": map[string]interface{}{
+				"sessionContext": map[string]interface{}{
+					"sessionIssuer": map[string]interface{}{"userName": fmt.Sprintf("ci-%d", i%5)},
+				},
+			},
+		}
+	}
+
+	return records
+}
@@ -0,0 +1,151 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// RuleTest sample cloudtrail record with the expected outcome of evaluating it against the whole rule set,
+// these are run when the configuration is loaded
+type RuleTest struct {
+	Name   string                 `yaml:"name" validate:"required"`
+	Record map[string]interface{} `yaml:"record" validate:"required"`
+	Expect string                 `yaml:"expect" validate:"required,oneof=drop keep"`
+	Rule   string                 `yaml:"rule,omitempty"`
+}
+
+// TestFailure a rule test which didn't produce the expected outcome
+type TestFailure struct {
+	Rule    string
+	Test    string
+	Message string
+}
+
+// TestFailuresError returned when one or more rule tests fail
+type TestFailuresError struct {
+	Failures []*TestFailure
+}
+
+func (te *TestFailuresError) Error() string {
+	msgs := make([]string, len(te.Failures))
+
+	for i, f := range te.Failures {
+		msgs[i] = fmt.Sprintf("rule %s test %q: %s", f.Rule, f.Test, f.Message)
+	}
+
+	return fmt.Sprintf("%d rule test(s) failed: %s", len(te.Failures), strings.Join(msgs, "; "))
+}
+
+// RunTests evaluate the tests for every rule in the configuration against the rule set, returning a
+// TestFailuresError if any produce an unexpected outcome. Tests for disabled or expired rules are skipped, and
+// the tests of a rule in dry run mode are evaluated as if it were enforced so it can be enforced later unchanged.
+func (rs *RuleSet) RunTests() error {
+	var failures []*TestFailure
+
+	for _, rule := range rs.cfg.Rules {
+		if !rs.isActive(rule) {
+			continue
+		}
+
+		for _, tc := range rule.Tests {
+			msg, err := rs.runTest(rule, tc)
+			if err != nil {
+				msg = err.Error()
+			}
+
+			if msg != "" {
+				failures = append(failures, &TestFailure{Rule: rule.Name, Test: tc.Name, Message: msg})
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return &TestFailuresError{Failures: failures}
+	}
+
+	return nil
+}
+
+// runTest evaluate the test returning a message describing the failure, or an empty string if it passed
+func (rs *RuleSet) runTest(rule *Rule, tc *RuleTest) (string, error) {
+	evt, err := normalizeRecord(tc.Record)
+	if err != nil {
+		return "", fmt.Errorf("invalid record: %w", err)
+	}
+
+	dec, err := rs.evaluate(evt, rule.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if dec.Action != tc.Expect {
+		return fmt.Sprintf("expected %s but record was %s by %s", tc.Expect, pastTense(dec.Action), decidedBy(dec)), nil
+	}
+
+	if tc.Rule != "" && tc.Rule != dec.Rule {
+		return fmt.Sprintf("expected rule %s to decide but record was %s by %s", tc.Rule, pastTense(dec.Action), decidedBy(dec)), nil
+	}
+
+	return "", nil
+}
+
+// normalizeRecord convert the record decoded from YAML into the same form as a record decoded from a
+// cloudtrail file, with string keys and json.Number values
+func normalizeRecord(record map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(normalizeValue(record))
+	if err != nil {
+		return nil, err
+	}
+
+	evt := make(map[string]interface{})
+
+	_, err = json.Parse(data, &evt, json.UseNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return evt, nil
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, mv := range val {
+			out[k] = normalizeValue(mv)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, mv := range val {
+			out[fmt.Sprint(k)] = normalizeValue(mv)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, lv := range val {
+			out[i] = normalizeValue(lv)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func pastTense(action string) string {
+	if action == ActionDrop {
+		return "dropped"
+	}
+
+	return "kept"
+}
+
+func decidedBy(dec *Decision) string {
+	if dec.Rule == "" {
+		return "the default action"
+	}
+
+	return "rule " + dec.Rule
+}
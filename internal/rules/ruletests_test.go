@@ -0,0 +1,143 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var yamlRuleTestsConfig = `
+---
+version: 2
+rules:
+  - name: check_kms
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    - field_name: eventName
+      op: in
+      values: [Decrypt, Encrypt]
+    tests:
+    - name: drops decrypt
+      expect: drop
+      rule: check_kms
+      record:
+        eventSource: kms.amazonaws.com
+        eventName: Decrypt
+        userIdentity:
+          type: AssumedRole
+    - name: keeps create key
+      expect: keep
+      record: {"eventSource": "kms.amazonaws.com", "eventName": "CreateKey", "apiVersion": 20141101}
+`
+
+func TestRuleSet_RunTests(t *testing.T) {
+	assert := require.New(t)
+
+	rs, err := LoadAndValidate(yamlRuleTestsConfig)
+	assert.NoError(err)
+	assert.NoError(rs.RunTests())
+}
+
+func TestRuleSet_RunTestsFailures(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: check_kms
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    tests:
+    - name: expects keep
+      expect: keep
+      record: {eventSource: kms.amazonaws.com}
+    - name: expects other rule
+      expect: drop
+      rule: check_s3
+      record: {eventSource: kms.amazonaws.com}
+    - name: passes
+      expect: keep
+      record: {eventSource: s3.amazonaws.com}
+`)
+	assert.Error(err)
+
+	var tfe *TestFailuresError
+	assert.True(errors.As(err, &tfe))
+	assert.Equal([]*TestFailure{
+		{Rule: "check_kms", Test: "expects keep", Message: "expected keep but record was dropped by rule check_kms"},
+		{Rule: "check_kms", Test: "expects other rule", Message: "expected rule check_s3 to decide but record was dropped by rule check_kms"},
+	}, tfe.Failures)
+	assert.Contains(err.Error(), `rule check_kms test "expects keep"`)
+}
+
+func TestRuleSet_RunTestsDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	// the tests of a rule in dry run mode expect the outcome once it is enforced, while the tests of other
+	// rules expect the outcome with it in dry run mode
+	rs, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: drop_describe
+    mode: dry_run
+    matches:
+    - field_name: eventName
+      op: prefix
+      value: Describe
+    tests:
+    - name: drops describe
+      expect: drop
+      rule: drop_describe
+      record: {eventName: DescribeInstances}
+  - name: drop_kms
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    tests:
+    - name: keeps describe while in dry run
+      expect: keep
+      record: {eventSource: ec2.amazonaws.com, eventName: DescribeInstances}
+`)
+	assert.NoError(err)
+
+	dec, err := rs.Evaluate(map[string]interface{}{"eventName": "DescribeInstances"})
+	assert.NoError(err)
+	assert.Equal(&Decision{Action: ActionKeep, DryRun: []string{"drop_describe"}}, dec)
+}
+
+func TestValidateRuleTests(t *testing.T) {
+	assert := require.New(t)
+
+	ctr, err := Load(`
+version: 2
+rules:
+  - name: check_kms
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: kms.amazonaws.com
+    tests:
+    - name: bad expect
+      expect: dropped
+      record: {eventSource: kms.amazonaws.com}
+`)
+	assert.NoError(err)
+	assert.Error(ctr.Validate())
+}
+
+func TestValidateRuleTests_NullTest(t *testing.T) {
+	_, err := LoadAndValidate(`
+version: 2
+rules:
+  - name: check_kms
+    when: record.eventSource == "kms.amazonaws.com"
+    tests: [~]
+`)
+	require.EqualError(t, err, `rules validation failed: line 6: rules[0].tests[0] in rule "check_kms": is required`)
+}
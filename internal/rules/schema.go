@@ -0,0 +1,317 @@
+package rules
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// JSONSchemaDraft the JSON Schema draft used by the generated schema
+const JSONSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// JSONSchema generate a JSON Schema describing the latest version of the configuration, this is derived
+// from the yaml and validate struct tags along with the operands required by each operator so it always
+// agrees with Validate. Regular expressions and CEL expressions are only checked when loaded.
+func JSONSchema() ([]byte, error) {
+	sg := &schemaGenerator{definitions: make(map[string]interface{})}
+
+	root := sg.structSchema(reflect.TypeOf(Configuration{}))
+	root["$schema"] = JSONSchemaDraft
+	root["title"] = "cloudtrail-log-processor rules configuration"
+	root["definitions"] = sg.definitions
+
+	return json.MarshalIndent(root, "", "  ")
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+// ref return a reference to the definition of the struct, adding it if missing
+func (sg *schemaGenerator) ref(t reflect.Type) map[string]interface{} {
+	if _, ok := sg.definitions[t.Name()]; !ok {
+		sg.definitions[t.Name()] = nil // reserve the name as Condition refers to itself
+		sg.definitions[t.Name()] = sg.structSchema(t)
+	}
+
+	return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+}
+
+func (sg *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	required := []string{}
+
+	sg.addFields(t, props, &required)
+
+	s := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+
+	if len(required) > 0 {
+		s["required"] = required
+	}
+
+	// constraints checked by the struct level validations
+	switch t {
+	case reflect.TypeOf(Rule{}):
+		s["anyOf"] = requireAnyOf(ruleConditionFields)
+		s["allOf"] = []interface{}{
+			requiredWhen("sample_rate", "action", ActionSample),
+			onlyWhen("sample_rate", "action", ActionSample),
+		}
+	case reflect.TypeOf(Condition{}):
+		s["anyOf"] = requireAnyOf(conditionFields)
+	case reflect.TypeOf(Match{}):
+		s["allOf"] = operandConstraints()
+	case reflect.TypeOf(List{}):
+		s["oneOf"] = requireAnyOf(listFields)
+	case reflect.TypeOf(Table{}):
+		s["oneOf"] = requireAnyOf(tableFields)
+	case reflect.TypeOf(FieldTransform{}):
+		s["allOf"] = []interface{}{
+			requiredWhen("value", "op", TransformReplace),
+			onlyWhen("value", "op", TransformReplace),
+			onlyWhen("keep_last", "op", TransformMask),
+		}
+	}
+
+	return s
+}
+
+func (sg *schemaGenerator) addFields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		fld := t.Field(i)
+		if fld.PkgPath != "" {
+			continue // unexported
+		}
+
+		yamlTag := strings.Split(fld.Tag.Get("yaml"), ",")
+		if len(yamlTag) > 1 && yamlTag[1] == "inline" {
+			sg.addFields(fld.Type, props, required)
+			continue
+		}
+
+		name := yamlTag[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		tags := strings.Split(fld.Tag.Get("validate"), ",")
+		if tags[0] == "required" {
+			*required = append(*required, name)
+		}
+
+		props[name] = sg.fieldSchema(fld.Type, tags)
+	}
+}
+
+// fieldSchema return the schema for a field with the provided validate tags, tags following dive apply
+// to the elements of a list or the values of a map
+func (sg *schemaGenerator) fieldSchema(t reflect.Type, tags []string) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var s map[string]interface{}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return sg.ref(t)
+	case reflect.Slice:
+		var elemTags []string
+		tags, elemTags = splitDive(tags)
+
+		s = map[string]interface{}{"type": "array", "items": sg.fieldSchema(t.Elem(), elemTags)}
+	case reflect.Map:
+		var elemTags []string
+		tags, elemTags = splitDive(tags)
+
+		s = map[string]interface{}{"type": "object", "additionalProperties": sg.fieldSchema(t.Elem(), elemTags)}
+	case reflect.String:
+		s = map[string]interface{}{"type": "string"}
+
+		// YAML booleans and numbers are accepted as operands and kept as written
+		if t == reflect.TypeOf(Scalar("")) {
+			s["type"] = []string{"string", "number", "boolean"}
+		}
+	case reflect.Int:
+		s = map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		s = map[string]interface{}{"type": "number"}
+	case reflect.Bool:
+		s = map[string]interface{}{"type": "boolean"}
+	default:
+		s = map[string]interface{}{}
+	}
+
+	for _, tag := range tags {
+		key, param := splitTag(tag)
+
+		switch key {
+		case "required":
+			if t.Kind() == reflect.String {
+				s["minLength"] = 1
+			}
+		case "oneof":
+			s["enum"] = enumValues(t, param)
+		case "operator":
+			s["enum"] = operators
+		case "field-path":
+			s["pattern"] = FieldPathPattern
+		case "is-regex":
+			s["format"] = "regex"
+		case "s3-url":
+			s["pattern"] = s3URLPattern
+		case "datetime":
+			s["format"] = "date"
+		case "min":
+			if t.Kind() == reflect.Slice {
+				s["minItems"] = schemaNumber(param)
+				continue
+			}
+
+			s["minimum"] = schemaNumber(param)
+		case "gt":
+			s["exclusiveMinimum"] = schemaNumber(param)
+		case "lte":
+			s["maximum"] = schemaNumber(param)
+		}
+	}
+
+	return s
+}
+
+// operandConstraints require the operands for each operator, including the default operator when op is omitted
+func operandConstraints() []interface{} {
+	ops := make([]string, 0, len(operatorOperands))
+	for op := range operatorOperands {
+		ops = append(ops, op)
+	}
+
+	sort.Strings(ops)
+
+	constraints := []interface{}{
+		map[string]interface{}{
+			"if":   map[string]interface{}{"not": map[string]interface{}{"required": []string{"op"}}},
+			"then": map[string]interface{}{"anyOf": requireAnyOf(operatorOperands[new(Match).Operator()])},
+		},
+	}
+
+	for _, names := range exclusiveOperands {
+		constraints = append(constraints, map[string]interface{}{"not": map[string]interface{}{"required": names}})
+	}
+
+	for _, op := range ops {
+		constraints = append(constraints, map[string]interface{}{
+			"if": map[string]interface{}{
+				"properties": map[string]interface{}{"op": map[string]interface{}{"const": op}},
+				"required":   []string{"op"},
+			},
+			"then": map[string]interface{}{"anyOf": requireAnyOf(operatorOperands[op])},
+		})
+	}
+
+	return constraints
+}
+
+// requiredWhen require the field when the property has the value
+func requiredWhen(name, prop, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"if":   propertyIs(prop, value),
+		"then": map[string]interface{}{"required": []string{name}},
+	}
+}
+
+// onlyWhen reject the field unless the property has the value
+func onlyWhen(name, prop, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"if":   map[string]interface{}{"required": []string{name}},
+		"then": propertyIs(prop, value),
+	}
+}
+
+func propertyIs(prop, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"properties": map[string]interface{}{prop: map[string]interface{}{"const": value}},
+		"required":   []string{prop},
+	}
+}
+
+// emptyValue matches an empty list, map or string, which are treated as missing by validation
+var emptyValue = map[string]interface{}{
+	"anyOf": []interface{}{
+		map[string]interface{}{"type": "array", "maxItems": 0},
+		map[string]interface{}{"type": "object", "maxProperties": 0},
+		map[string]interface{}{"type": "string", "maxLength": 0},
+	},
+}
+
+// requireAnyOf require at least one of the fields to be provided and not empty
+func requireAnyOf(fields []string) []interface{} {
+	alts := make([]interface{}, len(fields))
+
+	for i, name := range fields {
+		alts[i] = map[string]interface{}{
+			"required": []string{name},
+			"not": map[string]interface{}{
+				"properties": map[string]interface{}{name: emptyValue},
+			},
+		}
+	}
+
+	return alts
+}
+
+func enumValues(t reflect.Type, param string) []interface{} {
+	var values []interface{}
+
+	for _, v := range strings.Fields(param) {
+		if t.Kind() == reflect.Int {
+			n, err := strconv.Atoi(v)
+			if err == nil {
+				values = append(values, n)
+			}
+
+			continue
+		}
+
+		values = append(values, v)
+	}
+
+	return values
+}
+
+// schemaNumber return the number in a validate tag parameter
+func schemaNumber(param string) interface{} {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return param
+	}
+
+	return n
+}
+
+// splitDive split the tags into those for the field and those following dive
+func splitDive(tags []string) ([]string, []string) {
+	for i, tag := range tags {
+		if tag == "dive" {
+			return tags[:i], tags[i+1:]
+		}
+	}
+
+	return tags, nil
+}
+
+func splitTag(tag string) (string, string) {
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
@@ -0,0 +1,146 @@
+package rules
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+func TestJSONSchema(t *testing.T) {
+	assert := require.New(t)
+
+	data, err := JSONSchema()
+	assert.NoError(err)
+
+	schema := make(map[string]interface{})
+	assert.NoError(json.Unmarshal(data, &schema))
+	assert.Equal(JSONSchemaDraft, schema["$schema"])
+
+	var names []string
+	for name := range schema["definitions"].(map[string]interface{}) {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	assert.Equal([]string{"Condition", "Enrichment", "FieldTransform", "List", "Match", "Projection", "Rule", "RuleTest", "Table", "Transform"}, names)
+
+	props := schema["properties"].(map[string]interface{})
+	assert.Equal([]interface{}{float64(CurrentVersion)}, props["version"].(map[string]interface{})["enum"])
+
+	match := schema["definitions"].(map[string]interface{})["Match"].(map[string]interface{})
+	op := match["properties"].(map[string]interface{})["op"].(map[string]interface{})
+	assert.Len(op["enum"], len(operators))
+
+	// every operator has its operands listed, apart from those which only check presence
+	for _, op := range operators {
+		_, ok := operatorOperands[op]
+		assert.Equal(op != OpExists && op != OpNotExists && op != OpIsNull, ok, op)
+	}
+}
+
+func TestJSONSchema_AgreesWithValidate(t *testing.T) {
+	data, err := JSONSchema()
+	require.NoError(t, err)
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	require.NoError(t, err)
+
+	// regular expression and CEL syntax are only checked by Validate so they aren't covered here
+	tests := []struct {
+		name  string
+		cfg   string
+		valid bool
+	}{
+		{name: "should accept readme example", cfg: yamlRuleTestsConfig, valid: true},
+		{name: "should accept actions", cfg: yamlActionConfig, valid: true},
+		{name: "should accept when only rule", cfg: yamlWhenConfig, valid: true},
+		{name: "should accept nested conditions", cfg: `
+version: 2
+rules:
+  - name: nested
+    not:
+      any:
+        - matches:
+          - field_name: eventName
+            op: exists
+        - all:
+          - matches:
+            - field_name: sourceIPAddress
+              op: cidr
+              values: [10.0.0.0/8]
+`, valid: true},
+		{name: "should accept default regex operator", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      regex: Get.*\n", valid: true},
+		{name: "should accept typed values", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: readOnly\n      op: equals\n      value: true\n    - field_name: apiVersion\n      op: in\n      values: [20140630, 1.5]\n    - field_name: errorCode\n      op: is_null\n", valid: true},
+		{name: "should reject list value", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: equals\n      value: [Get]\n"},
+		{name: "should accept patterns and lists", cfg: yamlPatternsConfig, valid: true},
+		{name: "should accept stored lists", cfg: "version: 2\nlists:\n  a:\n    ssm: /lists/a\n  b:\n    s3: s3://bucket/lists/b.yaml\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: in\n      in_list: a\n", valid: true},
+		{name: "should reject list stored in more than one place", cfg: "version: 2\nlists:\n  a:\n    values: [Get]\n    ssm: /lists/a\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: in\n      in_list: a\n"},
+		{name: "should reject invalid s3 url", cfg: "version: 2\nlists:\n  a:\n    s3: bucket/a.yaml\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: in\n      in_list: a\n"},
+		{name: "should reject pattern with regex", cfg: "version: 2\npatterns:\n  a: ^Get\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      regex: ^Get\n      pattern: a\n"},
+		{name: "should reject in_list with values", cfg: "version: 2\nlists:\n  a:\n    values: [Get]\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: in\n      values: [Put]\n      in_list: a\n"},
+		{name: "should accept rule metadata", cfg: yamlMetadataConfig, valid: true},
+		{name: "should reject invalid expiry date", cfg: "version: 2\nrules:\n  - name: a\n    expires: 30/06/2021\n    when: has(record.eventName)\n"},
+		{name: "should reject enabled which isn't a boolean", cfg: "version: 2\nrules:\n  - name: a\n    enabled: sometimes\n    when: has(record.eventName)\n"},
+		{name: "should reject unknown mode", cfg: "version: 2\nrules:\n  - name: a\n    mode: audit\n    when: has(record.eventName)\n"},
+		{name: "should reject missing version", cfg: "rules:\n  - name: a\n    matches:\n    - field_name: eventName\n      regex: Get.*\n"},
+		{name: "should reject missing rules", cfg: "version: 2\n"},
+		{name: "should reject unknown field", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      regx: Get.*\n"},
+		{name: "should reject missing name", cfg: "version: 2\nrules:\n  - matches:\n    - field_name: eventName\n      regex: Get.*\n"},
+		{name: "should reject unknown action", cfg: "version: 2\nrules:\n  - name: a\n    action: discard\n    when: has(record.eventName)\n"},
+		{name: "should reject unknown operator", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: startswith\n      value: Get\n"},
+		{name: "should reject empty rule", cfg: "version: 2\nrules:\n  - name: a\n    matches: []\n"},
+		{name: "should reject empty not", cfg: "version: 2\nrules:\n  - name: a\n    when: has(record.eventName)\n    not: {}\n"},
+		{name: "should reject missing regex", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n"},
+		{name: "should reject missing value", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: equals\n      values: [Get]\n"},
+		{name: "should reject missing values", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: in\n      value: Get\n"},
+		{name: "should reject missing cidr", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: sourceIPAddress\n      op: cidr\n"},
+		{name: "should reject unknown on missing", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: eventName\n      op: exists\n      on_missing: skip\n"},
+		{name: "should reject invalid field path", cfg: "version: 2\nrules:\n  - name: a\n    matches:\n    - field_name: resources[a]\n      op: exists\n"},
+		{name: "should accept sample rule", cfg: "version: 2\nrules:\n  - name: a\n    action: sample\n    sample_rate: 0.1\n    when: has(record.eventName)\n", valid: true},
+		{name: "should reject sample rule without rate", cfg: "version: 2\nrules:\n  - name: a\n    action: sample\n    when: has(record.eventName)\n"},
+		{name: "should reject sample rate above one", cfg: "version: 2\nrules:\n  - name: a\n    action: sample\n    sample_rate: 1.5\n    when: has(record.eventName)\n"},
+		{name: "should reject sample rate without sample action", cfg: "version: 2\nrules:\n  - name: a\n    action: keep\n    sample_rate: 0.5\n    when: has(record.eventName)\n"},
+		{name: "should accept transforms", cfg: yamlTransformsConfig, valid: true},
+		{name: "should reject replace without value", cfg: "version: 2\nrules: []\ntransforms:\n  - name: a\n    fields:\n    - path: requestParameters.value\n      op: replace\n"},
+		{name: "should reject keep last without mask", cfg: "version: 2\nrules: []\ntransforms:\n  - name: a\n    fields:\n    - path: requestParameters.value\n      op: remove\n      keep_last: 4\n"},
+		{name: "should reject negative keep last", cfg: "version: 2\nrules: []\ntransforms:\n  - name: a\n    fields:\n    - path: requestParameters.value\n      op: mask\n      keep_last: -1\n"},
+		{name: "should reject transform without fields", cfg: "version: 2\nrules: []\ntransforms:\n  - name: a\n    when: has(record.eventName)\n"},
+		{name: "should accept projection", cfg: yamlProjectionConfig, valid: true},
+		{name: "should reject empty projection", cfg: "version: 2\nrules: []\nprojection:\n  fields: []\n"},
+		{name: "should reject empty event source projection", cfg: "version: 2\nrules: []\nprojection:\n  fields: [eventName]\n  event_sources:\n    s3.amazonaws.com: []\n"},
+		{name: "should accept enrichment", cfg: yamlEnrichmentConfig, valid: true},
+		{name: "should accept enrichment stored in s3", cfg: "version: 2\nrules: []\nenrichment:\n  accounts:\n    s3: s3://config-bucket/accounts.csv\n    format: csv\n", valid: true},
+		{name: "should reject enrichment without rows", cfg: "version: 2\nrules: []\nenrichment:\n  accounts:\n    rows: {}\n"},
+		{name: "should reject enrichment with rows and ssm", cfg: "version: 2\nrules: []\nenrichment:\n  accounts:\n    ssm: /config/accounts\n    rows:\n      \"123456789012\": {name: prod}\n"},
+		{name: "should reject unknown table format", cfg: "version: 2\nrules: []\nenrichment:\n  accounts:\n    ssm: /config/accounts\n    format: json\n"},
+		{name: "should reject test without record", cfg: "version: 2\nrules:\n  - name: a\n    when: has(record.eventName)\n    tests:\n    - name: t\n      expect: drop\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			doc := make(map[string]interface{})
+			assert.NoError(yaml.Unmarshal([]byte(tt.cfg), &doc))
+
+			res, err := schema.Validate(gojsonschema.NewGoLoader(doc))
+			assert.NoError(err)
+			assert.Equal(tt.valid, res.Valid(), "schema errors: %v", res.Errors())
+
+			// unversioned documents are upgraded when loaded, so only the schema rejects them
+			if _, ok := doc["version"]; !ok {
+				return
+			}
+
+			cfg, err := Load(tt.cfg)
+			if err == nil {
+				err = cfg.Validate()
+			}
+
+			assert.Equal(tt.valid, err == nil, "validate error: %v", err)
+		})
+	}
+}
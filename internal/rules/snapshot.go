@@ -0,0 +1,147 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/segmentio/encoding/json"
+)
+
+// Snapshot stores the documents of the last configuration which was successfully validated, this is
+// used when the configuration can't be loaded from the source
+type Snapshot interface {
+	Save(ctx context.Context, docs []*Document) error
+	Restore(ctx context.Context) ([]*Document, error)
+}
+
+// S3SnapshotAPI the subset of the S3 API used to store a snapshot in a bucket
+type S3SnapshotAPI interface {
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+}
+
+// snapshotDocument the encoded form of a document in a snapshot
+type snapshotDocument struct {
+	Source  string `json:"source"`
+	Content string `json:"content"`
+	List    string `json:"list,omitempty"`
+	Table   string `json:"table,omitempty"`
+}
+
+type fileSnapshot struct {
+	path string
+}
+
+// NewFileSnapshot create a snapshot stored in a local file
+func NewFileSnapshot(path string) Snapshot {
+	return &fileSnapshot{path: path}
+}
+
+func (fs *fileSnapshot) Save(ctx context.Context, docs []*Document) error {
+	data, err := encodeSnapshot(docs)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(fs.path, data, 0600)
+	if err != nil {
+		return fmt.Errorf("write snapshot to file failed: %w", err)
+	}
+
+	return nil
+}
+
+func (fs *fileSnapshot) Restore(ctx context.Context) ([]*Document, error) {
+	data, err := ioutil.ReadFile(fs.path)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot from file failed: %w", err)
+	}
+
+	return decodeSnapshot(data)
+}
+
+type s3Snapshot struct {
+	s3svc  S3SnapshotAPI
+	bucket string
+	key    string
+}
+
+// NewS3Snapshot create a snapshot stored in an s3 object
+func NewS3Snapshot(s3svc S3SnapshotAPI, bucket, key string) Snapshot {
+	return &s3Snapshot{s3svc: s3svc, bucket: bucket, key: key}
+}
+
+func (ss *s3Snapshot) Save(ctx context.Context, docs []*Document) error {
+	data, err := encodeSnapshot(docs)
+	if err != nil {
+		return err
+	}
+
+	_, err = ss.s3svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("write snapshot to s3 failed: %w", err)
+	}
+
+	return nil
+}
+
+func (ss *s3Snapshot) Restore(ctx context.Context) ([]*Document, error) {
+	res, err := ss.s3svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot from s3 failed: %w", err)
+	}
+
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot from s3 failed: %w", err)
+	}
+
+	return decodeSnapshot(data)
+}
+
+func encodeSnapshot(docs []*Document) ([]byte, error) {
+	sds := make([]*snapshotDocument, len(docs))
+
+	for i, doc := range docs {
+		sds[i] = &snapshotDocument{Source: doc.Source, Content: doc.Content, List: doc.List, Table: doc.Table}
+	}
+
+	return json.Marshal(sds)
+}
+
+func decodeSnapshot(data []byte) ([]*Document, error) {
+	var sds []*snapshotDocument
+
+	err := json.Unmarshal(data, &sds)
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot failed: %w", err)
+	}
+
+	if len(sds) == 0 {
+		return nil, fmt.Errorf("decode snapshot failed: snapshot is empty")
+	}
+
+	docs := make([]*Document, len(sds))
+
+	for i, sd := range sds {
+		docs[i] = &Document{Source: sd.Source, Content: sd.Content, List: sd.List, Table: sd.Table}
+	}
+
+	return docs, nil
+}
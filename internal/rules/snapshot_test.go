@@ -0,0 +1,58 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/mocks"
+)
+
+func TestNewS3Snapshot(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s3svc := mocks.NewMockS3SnapshotAPI(ctrl)
+
+	var stored []byte
+
+	s3svc.EXPECT().PutObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ aws.Context, in *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+			assert.Equal("snapshot-bucket", aws.StringValue(in.Bucket))
+			assert.Equal("rules.json", aws.StringValue(in.Key))
+
+			var err error
+			stored, err = ioutil.ReadAll(in.Body)
+
+			return &s3.PutObjectOutput{}, err
+		},
+	)
+	s3svc.EXPECT().GetObjectWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ aws.Context, _ *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(stored))}, nil
+		},
+	)
+
+	docs := []*Document{
+		{Source: "/rules/00-baseline", Content: yamlBaselineDoc},
+		{Source: "/rules/team-a", Content: yamlTeamDoc},
+		{Source: "/lists/ci_roles", Content: "[ci-deploy]", List: "ci_roles"},
+		{Source: "/tables/accounts", Content: "account,name\n123456789012,prod\n", Table: "accounts"},
+	}
+
+	snapshot := NewS3Snapshot(s3svc, "snapshot-bucket", "rules.json")
+	assert.NoError(snapshot.Save(context.TODO(), docs))
+
+	got, err := snapshot.Restore(context.TODO())
+	assert.NoError(err)
+	assert.Equal(docs, got)
+}
@@ -0,0 +1,339 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/wolfeidau/ssmcache"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/internal/s3url"
+)
+
+// sourceExpiry how long documents read from remote sources are cached, this matches ssmcache
+var sourceExpiry = 30 * time.Second
+
+// ConfigSource a source of configuration documents, these are merged into a single configuration
+type ConfigSource interface {
+	Documents(ctx context.Context) ([]*Document, error)
+}
+
+// SSMPathAPI the subset of the SSM API used to read the configuration documents stored under a path
+type SSMPathAPI interface {
+	GetParametersByPathPagesWithContext(
+		aws.Context, *ssm.GetParametersByPathInput, func(*ssm.GetParametersByPathOutput, bool) bool, ...request.Option,
+	) error
+}
+
+// S3API the subset of the S3 API used to read a configuration document stored in a bucket
+type S3API interface {
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+}
+
+type ssmSource struct {
+	ssm  ssmcache.Cache
+	path string
+}
+
+// NewSSMSource create a source which reads the configuration stored in a single ssm parameter
+func NewSSMSource(ssm ssmcache.Cache, path string) ConfigSource {
+	return &ssmSource{ssm: ssm, path: path}
+}
+
+func (ss *ssmSource) Documents(ctx context.Context) ([]*Document, error) {
+	rawCfg, err := ss.ssm.GetKey(ss.path, false) // config is not encrypted
+	if err != nil {
+		return nil, fmt.Errorf("read config from ssm failed: %w", err)
+	}
+
+	return []*Document{{Source: ss.path, Content: rawCfg}}, nil
+}
+
+type ssmPathSource struct {
+	ssmsvc SSMPathAPI
+	path   string
+}
+
+// NewSSMPathSource create a source which reads every ssm parameter under the path, including nested paths, these
+// are ordered by name so rules can be ordered across documents using prefixes such as 00-baseline
+func NewSSMPathSource(ssmsvc SSMPathAPI, path string) ConfigSource {
+	return &cachedSource{src: &ssmPathSource{ssmsvc: ssmsvc, path: path}, expiry: sourceExpiry}
+}
+
+func (ps *ssmPathSource) Documents(ctx context.Context) ([]*Document, error) {
+	var docs []*Document
+
+	err := ps.ssmsvc.GetParametersByPathPagesWithContext(ctx, &ssm.GetParametersByPathInput{
+		Path:           aws.String(ps.path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(false), // config is not encrypted
+	}, func(out *ssm.GetParametersByPathOutput, _ bool) bool {
+		for _, p := range out.Parameters {
+			docs = append(docs, &Document{Source: aws.StringValue(p.Name), Content: aws.StringValue(p.Value)})
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read config from ssm path failed: %w", err)
+	}
+
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no config found under ssm path %s", ps.path)
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Source < docs[j].Source
+	})
+
+	return docs, nil
+}
+
+type s3Source struct {
+	s3svc  S3API
+	bucket string
+	key    string
+}
+
+// NewS3Source create a source which reads the configuration stored in an s3 object, this avoids the size
+// limits on ssm parameters for large rule sets
+func NewS3Source(s3svc S3API, bucket, key string) ConfigSource {
+	return &cachedSource{src: &s3Source{s3svc: s3svc, bucket: bucket, key: key}, expiry: sourceExpiry}
+}
+
+func (ss *s3Source) Documents(ctx context.Context) ([]*Document, error) {
+	res, err := ss.s3svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read config from s3 failed: %w", err)
+	}
+
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read config from s3 failed: %w", err)
+	}
+
+	return []*Document{{Source: fmt.Sprintf("s3://%s/%s", ss.bucket, ss.key), Content: string(data)}}, nil
+}
+
+type fileSource struct {
+	path string
+}
+
+// NewFileSource create a source which reads the configuration from a local file, this is used when running
+// the processor outside of lambda
+func NewFileSource(path string) ConfigSource {
+	return &fileSource{path: path}
+}
+
+func (fs *fileSource) Documents(ctx context.Context) ([]*Document, error) {
+	data, err := ioutil.ReadFile(fs.path)
+	if err != nil {
+		return nil, fmt.Errorf("read config from file failed: %w", err)
+	}
+
+	return []*Document{{Source: fs.path, Content: string(data)}}, nil
+}
+
+type envSource struct {
+	name string
+}
+
+// NewEnvSource create a source which reads the configuration inline from an environment variable
+func NewEnvSource(name string) ConfigSource {
+	return &envSource{name: name}
+}
+
+func (es *envSource) Documents(ctx context.Context) ([]*Document, error) {
+	rawCfg, ok := os.LookupEnv(es.name)
+	if !ok {
+		return nil, fmt.Errorf("read config from environment failed: %s is not set", es.name)
+	}
+
+	return []*Document{{Source: "$" + es.name, Content: rawCfg}}, nil
+}
+
+// cachedSource caches the documents read from a source, to avoid reading remote sources for every file
+type cachedSource struct {
+	src    ConfigSource
+	expiry time.Duration
+
+	mu      sync.Mutex
+	docs    []*Document
+	expires time.Time
+}
+
+func (cs *cachedSource) Documents(ctx context.Context) ([]*Document, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.docs != nil && time.Now().Before(cs.expires) {
+		return cs.docs, nil
+	}
+
+	docs, err := cs.src.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cs.docs, cs.expires = docs, time.Now().Add(cs.expiry)
+
+	return docs, nil
+}
+
+type listSource struct {
+	src   ConfigSource
+	ssm   ssmcache.Cache
+	s3svc S3API
+
+	mu      sync.Mutex
+	sources map[string]ConfigSource
+
+	// the lists and tables stored in ssm or s3 by the last documents read from the source, these are reused
+	// while the documents are unchanged to avoid merging them for every file
+	refsMu   sync.Mutex
+	refsDocs []*Document
+	refs     []*remoteRef
+}
+
+// remoteRef a list or table which the configuration stores in an ssm parameter or s3 object
+type remoteRef struct {
+	kind  string
+	name  string
+	param string
+	s3URL string
+}
+
+// NewListSource wrap the source so the lists and tables which the configuration stores in ssm or s3 are read along
+// with it, each is returned as a separate document so changes are detected and snapshotted like the rules
+func NewListSource(src ConfigSource, ssm ssmcache.Cache, s3svc S3API) ConfigSource {
+	return &listSource{src: src, ssm: ssm, s3svc: s3svc, sources: make(map[string]ConfigSource)}
+}
+
+func (ls *listSource) Documents(ctx context.Context) ([]*Document, error) {
+	docs, err := ls.src.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := ls.remoteRefs(docs)
+	if len(refs) == 0 {
+		return docs, nil
+	}
+
+	// avoid appending to the documents cached by the source
+	docs = append([]*Document{}, docs...)
+
+	for _, ref := range refs {
+		remoteDocs, err := ls.documents(ctx, ref.param, ref.s3URL)
+		if err != nil {
+			return nil, fmt.Errorf("read %s %s failed: %w", ref.kind, ref.name, err)
+		}
+
+		for _, doc := range remoteDocs {
+			remote := &Document{Source: doc.Source, Content: doc.Content}
+
+			if ref.kind == "list" {
+				remote.List = ref.name
+			} else {
+				remote.Table = ref.name
+			}
+
+			docs = append(docs, remote)
+		}
+	}
+
+	return docs, nil
+}
+
+// remoteRefs return the lists and tables the documents store in ssm or s3, the documents are only merged
+// when they change
+func (ls *listSource) remoteRefs(docs []*Document) []*remoteRef {
+	ls.refsMu.Lock()
+	defer ls.refsMu.Unlock()
+
+	if ls.refsDocs != nil && sameDocuments(docs, ls.refsDocs) {
+		return ls.refs
+	}
+
+	var refs []*remoteRef
+
+	// an invalid configuration has no references, it is reported when the configuration is validated
+	if cfg, err := Merge(docs); err == nil {
+		for _, name := range cfg.listNames() {
+			list := cfg.Lists[name]
+			if list != nil && list.isRemote() {
+				refs = append(refs, &remoteRef{kind: "list", name: name, param: list.SSM, s3URL: list.S3})
+			}
+		}
+
+		tables := cfg.tables()
+
+		for _, name := range cfg.tableNames() {
+			if table := tables[name]; table.isRemote() {
+				refs = append(refs, &remoteRef{kind: "table", name: name, param: table.SSM, s3URL: table.S3})
+			}
+		}
+	}
+
+	ls.refsDocs, ls.refs = docs, refs
+
+	return refs
+}
+
+// documents read the documents stored in the ssm parameter or s3 object
+func (ls *listSource) documents(ctx context.Context, param, s3URL string) ([]*Document, error) {
+	src, err := ls.remoteSource(param, s3URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return src.Documents(ctx)
+}
+
+// remoteSource return the source for the ssm parameter or s3 object, these are reused so documents read from s3
+// are cached
+func (ls *listSource) remoteSource(param, s3URL string) (ConfigSource, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	location := param
+	if s3URL != "" {
+		location = s3URL
+	}
+
+	if src, ok := ls.sources[location]; ok {
+		return src, nil
+	}
+
+	var src ConfigSource
+
+	if s3URL != "" {
+		bucket, key, err := s3url.Parse(s3URL)
+		if err != nil {
+			return nil, err
+		}
+
+		src = NewS3Source(ls.s3svc, bucket, key)
+	} else {
+		src = NewSSMSource(ls.ssm, param)
+	}
+
+	ls.sources[location] = src
+
+	return src, nil
+}
@@ -0,0 +1,212 @@
+package rules
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/mocks"
+)
+
+func TestNewSSMPathSource(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ssmsvc := mocks.NewMockSSMPathAPI(ctrl)
+
+	ssmsvc.EXPECT().GetParametersByPathPagesWithContext(gomock.Any(), &ssm.GetParametersByPathInput{
+		Path:           aws.String("/config/rules"),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(false),
+	}, gomock.Any()).DoAndReturn(
+		func(_ aws.Context, _ *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool, _ ...interface{}) error {
+			// returned out of order over two pages
+			fn(&ssm.GetParametersByPathOutput{Parameters: []*ssm.Parameter{
+				{Name: aws.String("/config/rules/team-a"), Value: aws.String(yamlTeamDoc)},
+			}}, false)
+			fn(&ssm.GetParametersByPathOutput{Parameters: []*ssm.Parameter{
+				{Name: aws.String("/config/rules/00-baseline"), Value: aws.String(yamlBaselineDoc)},
+			}}, true)
+
+			return nil
+		},
+	)
+
+	ld := NewLoader(NewSSMPathSource(ssmsvc, "/config/rules"))
+
+	first, err := ld.Load(context.TODO())
+	assert.NoError(err)
+	assert.Equal("check_kms", first.Configuration().Rules[0].Name)
+	assert.Equal("check_s3", first.Configuration().Rules[1].Name)
+
+	// the documents are cached so ssm is only called once
+	second, err := ld.Load(context.TODO())
+	assert.NoError(err)
+	assert.Same(first, second)
+}
+
+func TestNewS3Source(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s3svc := mocks.NewMockS3API(ctrl)
+
+	s3svc.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String("config-bucket"),
+		Key:    aws.String("rules.yaml"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(yamlConfig))}, nil)
+
+	docs, err := NewS3Source(s3svc, "config-bucket", "rules.yaml").Documents(context.TODO())
+	assert.NoError(err)
+	assert.Equal([]*Document{{Source: "s3://config-bucket/rules.yaml", Content: yamlConfig}}, docs)
+}
+
+func TestNewFileSource(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "rules")
+	assert.NoError(err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.yaml")
+	assert.NoError(ioutil.WriteFile(path, []byte(yamlConfig), 0600))
+
+	rs, err := LoadFromSourceAndValidate(context.TODO(), NewFileSource(path))
+	assert.NoError(err)
+	assert.Equal(path, rs.Configuration().Rules[0].Source)
+
+	_, err = NewFileSource(filepath.Join(dir, "missing.yaml")).Documents(context.TODO())
+	assert.Error(err)
+}
+
+func TestNewEnvSource(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := NewEnvSource("TEST_CONFIG_RULES").Documents(context.TODO())
+	assert.EqualError(err, "read config from environment failed: TEST_CONFIG_RULES is not set")
+
+	assert.NoError(os.Setenv("TEST_CONFIG_RULES", yamlConfig))
+	defer os.Unsetenv("TEST_CONFIG_RULES")
+
+	docs, err := NewEnvSource("TEST_CONFIG_RULES").Documents(context.TODO())
+	assert.NoError(err)
+	assert.Equal([]*Document{{Source: "$TEST_CONFIG_RULES", Content: yamlConfig}}, docs)
+}
+
+const yamlRemoteListsConfig = `
+version: 2
+lists:
+  ci_roles:
+    ssm: /config/lists/ci_roles
+  partner_accounts:
+    s3: s3://config-bucket/lists/partners.yaml
+rules:
+  - name: ci_roles
+    matches:
+    - field_name: userIdentity.sessionContext.sessionIssuer.userName
+      op: in
+      in_list: ci_roles
+  - name: partners
+    matches:
+    - field_name: recipientAccountId
+      op: in
+      in_list: partner_accounts
+`
+
+func TestNewListSource(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cache := mocks.NewMockCache(ctrl)
+	s3svc := mocks.NewMockS3API(ctrl)
+
+	cache.EXPECT().GetKey("/config/rules", false).Return(yamlRemoteListsConfig, nil).Times(2)
+	cache.EXPECT().GetKey("/config/lists/ci_roles", false).Return("[ci-deploy, ci-release]", nil).Times(2)
+
+	// the s3 object is cached by the source
+	s3svc.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String("config-bucket"),
+		Key:    aws.String("lists/partners.yaml"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(`["012345678901"]`))}, nil)
+
+	src := NewListSource(NewSSMSource(cache, "/config/rules"), cache, s3svc)
+
+	docs, err := src.Documents(context.TODO())
+	assert.NoError(err)
+	assert.Equal([]*Document{
+		{Source: "/config/rules", Content: yamlRemoteListsConfig},
+		{Source: "/config/lists/ci_roles", Content: "[ci-deploy, ci-release]", List: "ci_roles"},
+		{Source: "s3://config-bucket/lists/partners.yaml", Content: `["012345678901"]`, List: "partner_accounts"},
+	}, docs)
+
+	rs, err := MergeAndValidate(docs)
+	assert.NoError(err)
+
+	dec, err := rs.Evaluate(map[string]interface{}{"recipientAccountId": "012345678901"})
+	assert.NoError(err)
+	assert.Equal("partners", dec.Rule)
+
+	again, err := src.Documents(context.TODO())
+	assert.NoError(err)
+	assert.Equal(docs, again)
+}
+
+// staticSource returns the same documents until they are replaced
+type staticSource struct {
+	docs []*Document
+}
+
+func (ss *staticSource) Documents(ctx context.Context) ([]*Document, error) {
+	return ss.docs, nil
+}
+
+func TestNewListSource_ReusesReferences(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cache := mocks.NewMockCache(ctrl)
+	cache.EXPECT().GetKey("/config/lists/ci_roles", false).Return("[ci-deploy]", nil).Times(2)
+
+	rulesDoc := "version: 2\nlists:\n  ci_roles:\n    ssm: /config/lists/ci_roles\nrules: []\n"
+
+	src := &staticSource{docs: []*Document{{Source: "/config/rules", Content: rulesDoc}}}
+	ls := NewListSource(src, cache, mocks.NewMockS3API(ctrl)).(*listSource)
+
+	docs, err := ls.Documents(context.TODO())
+	assert.NoError(err)
+	assert.Len(docs, 2)
+
+	refs := ls.refs
+
+	// the unchanged documents aren't merged again
+	again, err := ls.Documents(context.TODO())
+	assert.NoError(err)
+	assert.Equal(docs, again)
+	assert.Same(refs[0], ls.refs[0])
+
+	// changed documents are merged to find the lists they reference
+	src.docs = []*Document{{Source: "/config/rules", Content: "version: 2\nrules: []\n"}}
+
+	docs, err = ls.Documents(context.TODO())
+	assert.NoError(err)
+	assert.Equal(src.docs, docs)
+	assert.Empty(ls.refs)
+}
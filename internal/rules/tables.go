@@ -0,0 +1,178 @@
+package rules
+
+import (
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+)
+
+// Enrichment the lookup tables used to add fields describing the accounts in each retained record
+type Enrichment struct {
+	Accounts *Table `yaml:"accounts" validate:"required"`
+}
+
+// Fields added to retained records by enrichment, describing the account which received the event and the
+// account of the principal which made the request
+const (
+	AccountField          = "x_account"
+	PrincipalAccountField = "x_principal_account"
+)
+
+// Table a lookup table containing the attributes for each key, such as the name and owner of each account. The
+// rows are either provided inline or stored in a separate ssm parameter or s3 object, as YAML mapping each key to
+// its attributes or as CSV with a header row where the first column is the key.
+type Table struct {
+	Rows     map[string]map[string]string `yaml:"rows,omitempty"`
+	Location `yaml:",inline"`
+	Format   string `yaml:"format,omitempty" validate:"omitempty,oneof=yaml csv"`
+
+	// loaded the rows read from ssm or s3
+	loaded map[string]map[string]string
+}
+
+// Formats of a table stored in ssm or s3
+const (
+	TableFormatYAML = "yaml"
+	TableFormatCSV  = "csv"
+)
+
+// tableFields the fields of a table which provide its rows, exactly one must be provided
+var tableFields = []string{"rows", "ssm", "s3"}
+
+// rows return the rows provided inline or loaded from ssm or s3
+func (t *Table) rows() map[string]map[string]string {
+	if t.isRemote() {
+		return t.loaded
+	}
+
+	return t.Rows
+}
+
+// ValidateTable implements validator.StructLevelFunc, ensuring the rows of the table are provided in exactly one way
+func ValidateTable(sl validator.StructLevel) {
+	table, ok := sl.Current().Interface().(Table)
+	if !ok {
+		return
+	}
+
+	table.Location.validate(sl, "rows", "Rows", len(table.Rows) > 0)
+}
+
+// decodeTable decode the rows of a table stored in ssm or s3 using the format of the table
+func decodeTable(content, format string) (map[string]map[string]string, error) {
+	if format == TableFormatCSV {
+		return decodeCSVTable(content)
+	}
+
+	rows := make(map[string]map[string]string)
+
+	err := yaml.Unmarshal([]byte(content), &rows)
+	if err != nil {
+		return nil, fmt.Errorf("decode table failed: %w", err)
+	}
+
+	return rows, nil
+}
+
+// decodeCSVTable decode a table with a header row naming the columns, the first column is the key and empty
+// values are omitted
+func decodeCSVTable(content string) (map[string]map[string]string, error) {
+	records, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("decode table failed: %w", err)
+	}
+
+	if len(records) == 0 || len(records[0]) < 2 {
+		return nil, fmt.Errorf("decode table failed: header must name the key column and at least one attribute")
+	}
+
+	header := records[0]
+	rows := make(map[string]map[string]string, len(records)-1)
+
+	for i, record := range records[1:] {
+		key := strings.TrimSpace(record[0])
+
+		if _, ok := rows[key]; ok {
+			return nil, fmt.Errorf("decode table failed: line %d: duplicate key %s", i+2, key)
+		}
+
+		row := make(map[string]string, len(header)-1)
+
+		for col, name := range header[1:] {
+			if v := strings.TrimSpace(record[col+1]); v != "" {
+				row[strings.TrimSpace(name)] = v
+			}
+		}
+
+		rows[key] = row
+	}
+
+	return rows, nil
+}
+
+// tables return the lookup tables by name
+func (cr *Configuration) tables() map[string]*Table {
+	tables := make(map[string]*Table)
+
+	if cr.Enrichment != nil && cr.Enrichment.Accounts != nil {
+		tables["accounts"] = cr.Enrichment.Accounts
+	}
+
+	return tables
+}
+
+// tableNames return the names of the lookup tables in order
+func (cr *Configuration) tableNames() []string {
+	tables := cr.tables()
+
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+type compiledEnrichment struct {
+	accounts map[string]map[string]string
+}
+
+func compileEnrichment(en *Enrichment) (*compiledEnrichment, error) {
+	if en.Accounts.isRemote() && en.Accounts.loaded == nil {
+		return nil, fmt.Errorf("table accounts has not been loaded from %s", en.Accounts.location())
+	}
+
+	return &compiledEnrichment{accounts: en.Accounts.rows()}, nil
+}
+
+// Enrich return the fields describing the account which received the event and the account of the principal
+// which made the request, using the recipientAccountId and userIdentity.accountId of the record
+func (rs *RuleSet) Enrich(evt map[string]interface{}) map[string]interface{} {
+	if rs.enrichment == nil {
+		return nil
+	}
+
+	fields := make(map[string]interface{})
+
+	if id, ok := evt["recipientAccountId"].(string); ok {
+		if row, ok := rs.enrichment.accounts[id]; ok {
+			fields[AccountField] = row
+		}
+	}
+
+	if identity, ok := evt["userIdentity"].(map[string]interface{}); ok {
+		if id, ok := identity["accountId"].(string); ok {
+			if row, ok := rs.enrichment.accounts[id]; ok {
+				fields[PrincipalAccountField] = row
+			}
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,218 @@
+package rules
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wolfeidau/cloudtrail-log-processor/mocks"
+)
+
+var yamlEnrichmentConfig = `
+version: 2
+rules: []
+enrichment:
+  accounts:
+    rows:
+      "123456789012":
+        name: prod
+        environment: production
+        owner_team: platform
+      "210987654321":
+        name: ci
+        environment: tooling
+`
+
+var yamlRemoteTableConfig = `
+version: 2
+rules:
+  - name: drop_describe
+    matches:
+    - field_name: eventName
+      op: prefix
+      value: Describe
+enrichment:
+  accounts:
+    s3: s3://config-bucket/tables/accounts.csv
+    format: csv
+`
+
+func TestRuleSet_Enrich(t *testing.T) {
+	rs, err := LoadAndValidate(yamlEnrichmentConfig)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		evt  map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "should enrich recipient and principal accounts",
+			evt: map[string]interface{}{
+				"recipientAccountId": "123456789012",
+				"userIdentity":       map[string]interface{}{"accountId": "210987654321"},
+			},
+			want: map[string]interface{}{
+				AccountField:          map[string]string{"name": "prod", "environment": "production", "owner_team": "platform"},
+				PrincipalAccountField: map[string]string{"name": "ci", "environment": "tooling"},
+			},
+		},
+		{
+			name: "should skip unknown accounts",
+			evt: map[string]interface{}{
+				"recipientAccountId": "123456789012",
+				"userIdentity":       map[string]interface{}{"accountId": "999999999999"},
+			},
+			want: map[string]interface{}{
+				AccountField: map[string]string{"name": "prod", "environment": "production", "owner_team": "platform"},
+			},
+		},
+		{
+			name: "should skip records without account ids",
+			evt:  map[string]interface{}{"eventName": "ConsoleLogin"},
+			want: map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, rs.Enrich(tt.evt))
+		})
+	}
+}
+
+func TestRuleSet_EnrichNotConfigured(t *testing.T) {
+	rs, err := LoadAndValidate(yamlBenchConfig)
+	require.NoError(t, err)
+	require.Nil(t, rs.Enrich(map[string]interface{}{"recipientAccountId": "123456789012"}))
+}
+
+func TestDecodeTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		format  string
+		want    map[string]map[string]string
+		wantErr string
+	}{
+		{
+			name:    "should decode yaml",
+			content: "\"123456789012\":\n  name: prod\n  owner_team: platform\n",
+			want:    map[string]map[string]string{"123456789012": {"name": "prod", "owner_team": "platform"}},
+		},
+		{
+			name:    "should decode csv omitting empty values",
+			content: "account_id,name,owner_team\n123456789012,prod,platform\n210987654321,ci,\n",
+			format:  TableFormatCSV,
+			want: map[string]map[string]string{
+				"123456789012": {"name": "prod", "owner_team": "platform"},
+				"210987654321": {"name": "ci"},
+			},
+		},
+		{
+			name:    "should reject duplicate csv keys",
+			content: "account_id,name\n123456789012,prod\n123456789012,ci\n",
+			format:  TableFormatCSV,
+			wantErr: "decode table failed: line 3: duplicate key 123456789012",
+		},
+		{
+			name:    "should reject csv without attributes",
+			content: "account_id\n123456789012\n",
+			format:  TableFormatCSV,
+			wantErr: "decode table failed: header must name the key column and at least one attribute",
+		},
+		{
+			name:    "should reject invalid yaml",
+			content: "[prod]",
+			wantErr: "decode table failed: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into map[string]map[string]string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeTable(tt.content, tt.format)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateEnrichment(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := Load(`
+version: 2
+rules: []
+enrichment:
+  accounts:
+    ssm: /config/tables/accounts
+    s3: s3://config-bucket/tables/accounts.csv
+    format: json
+`)
+	assert.NoError(err)
+	assert.EqualError(cfg.Validate(), `line 8: enrichment.accounts.format: "json" is not valid, must be one of yaml, csv; `+
+		`line 7: enrichment.accounts.s3: can't be used with ssm`)
+}
+
+func TestCompile_RemoteTableNotLoaded(t *testing.T) {
+	_, err := LoadAndValidate(yamlRemoteTableConfig)
+	require.EqualError(t, err, "rules compile failed: enrichment: table accounts has not been loaded from s3://config-bucket/tables/accounts.csv")
+}
+
+func TestMerge_Enrichment(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := Merge([]*Document{
+		{Source: "a.yaml", Content: yamlEnrichmentConfig},
+		{Source: "b.yaml", Content: yamlRemoteTableConfig},
+	})
+	assert.EqualError(err, `b.yaml: line 10: enrichment: already configured in a.yaml`)
+
+	_, err = Merge([]*Document{
+		{Source: "a.yaml", Content: yamlEnrichmentConfig},
+		{Source: "/config/tables/accounts", Content: "{}", Table: "accounts"},
+	})
+	assert.EqualError(err, `/config/tables/accounts: table accounts is not stored in ssm or s3`)
+}
+
+func TestNewListSource_Tables(t *testing.T) {
+	assert := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cache := mocks.NewMockCache(ctrl)
+	s3svc := mocks.NewMockS3API(ctrl)
+
+	table := "account_id,name,environment\n123456789012,prod,production\n"
+
+	cache.EXPECT().GetKey("/config/rules", false).Return(yamlRemoteTableConfig, nil)
+	s3svc.EXPECT().GetObjectWithContext(gomock.Any(), &s3.GetObjectInput{
+		Bucket: aws.String("config-bucket"),
+		Key:    aws.String("tables/accounts.csv"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(table))}, nil)
+
+	src := NewListSource(NewSSMSource(cache, "/config/rules"), cache, s3svc)
+
+	docs, err := src.Documents(context.TODO())
+	assert.NoError(err)
+	assert.Equal([]*Document{
+		{Source: "/config/rules", Content: yamlRemoteTableConfig},
+		{Source: "s3://config-bucket/tables/accounts.csv", Content: table, Table: "accounts"},
+	}, docs)
+
+	rs, err := MergeAndValidate(docs)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		AccountField: map[string]string{"name": "prod", "environment": "production"},
+	}, rs.Enrich(map[string]interface{}{"recipientAccountId": "123456789012"}))
+}
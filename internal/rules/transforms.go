@@ -0,0 +1,211 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+)
+
+// Transform transform with a name, an optional condition and an optional CEL expression, the fields are changed
+// in each retained record which matches, for example to remove or mask sensitive request parameters. A transform
+// without a condition or expression changes every retained record.
+type Transform struct {
+	Name      string `yaml:"name" validate:"required"`
+	Condition `yaml:",inline"`
+	When      string            `yaml:"when,omitempty" validate:"omitempty,cel"`
+	Fields    []*FieldTransform `yaml:"fields" validate:"required,dive,required"`
+
+	// Source where the transform was loaded from when merging multiple documents
+	Source string `yaml:"-"`
+
+	// node and index locate the transform in the YAML document it was loaded from when merging multiple documents
+	node  *yaml.Node
+	index int
+}
+
+// Operations which change a field in a record
+const (
+	TransformRemove  = "remove"
+	TransformMask    = "mask"
+	TransformReplace = "replace"
+)
+
+// maskPrefix replaces the masked part of a value, this has a fixed length so the length of the value isn't revealed
+const maskPrefix = "****"
+
+// FieldTransform the field to change and the operation used to change it, fields which are missing from the
+// record are left unchanged. A masked value keeps the last keep_last characters, values which are no longer
+// than this are masked entirely, and each value in a masked object or array is masked.
+type FieldTransform struct {
+	Path     string `yaml:"path" validate:"required,field-path"`
+	Op       string `yaml:"op" validate:"required,oneof=remove mask replace"`
+	KeepLast int    `yaml:"keep_last,omitempty" validate:"omitempty,min=0"`
+	Value    string `yaml:"value,omitempty"`
+}
+
+// ValidateFieldTransform implements validator.StructLevelFunc, ensuring the operands are only used with their operation
+func ValidateFieldTransform(sl validator.StructLevel) {
+	ft, ok := sl.Current().Interface().(FieldTransform)
+	if !ok {
+		return
+	}
+
+	if ft.KeepLast != 0 && ft.Op != TransformMask {
+		sl.ReportError(ft.KeepLast, "keep_last", "KeepLast", "excluded_unless", "op "+TransformMask)
+	}
+
+	switch {
+	case ft.Op == TransformReplace && ft.Value == "":
+		sl.ReportError(ft.Value, "value", "Value", "required_if", "op "+TransformReplace)
+	case ft.Op != TransformReplace && ft.Value != "":
+		sl.ReportError(ft.Value, "value", "Value", "excluded_unless", "op "+TransformReplace)
+	}
+}
+
+type compiledTransform struct {
+	name   string
+	cond   *compiledCondition
+	when   cel.Program
+	fields []*compiledFieldTransform
+}
+
+type compiledFieldTransform struct {
+	path     Path
+	op       string
+	keepLast int
+	value    string
+}
+
+func compileTransform(tr *Transform) (*compiledTransform, error) {
+	cc, err := compileCondition(&tr.Condition)
+	if err != nil {
+		return nil, err
+	}
+
+	ct := &compiledTransform{name: tr.Name, cond: cc}
+
+	if tr.When != "" {
+		ct.when, err = CompileExpression(tr.When)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile when expression: %w", err)
+		}
+	}
+
+	for _, ft := range tr.Fields {
+		p, err := ParsePath(ft.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		ct.fields = append(ct.fields, &compiledFieldTransform{path: p, op: ft.Op, keepLast: ft.KeepLast, value: ft.Value})
+	}
+
+	return ct, nil
+}
+
+// apply change the fields of the record if it matches the condition and expression, returning true if it matched
+func (ct *compiledTransform) apply(evt map[string]interface{}) (bool, error) {
+	match, err := ct.cond.eval(evt)
+	if err != nil || !match {
+		return false, err
+	}
+
+	if ct.when != nil {
+		match, err = evalExpression(ct.when, evt)
+		if err != nil || !match {
+			return false, err
+		}
+	}
+
+	for _, cf := range ct.fields {
+		cf.apply(evt)
+	}
+
+	return true, nil
+}
+
+func (cf *compiledFieldTransform) apply(evt map[string]interface{}) {
+	switch cf.op {
+	case TransformRemove:
+		cf.path.Delete(evt)
+	case TransformReplace:
+		cf.path.Set(evt, cf.value)
+	case TransformMask:
+		if v, ok := cf.path.Lookup(evt); ok {
+			cf.path.Set(evt, mask(v, cf.keepLast))
+		}
+	}
+}
+
+// mask mask the value keeping the last characters, each value in an object or array is masked and null is unchanged
+func mask(v interface{}, keepLast int) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		for k, elem := range val {
+			val[k] = mask(elem, keepLast)
+		}
+
+		return val
+	case []interface{}:
+		for i, elem := range val {
+			val[i] = mask(elem, keepLast)
+		}
+
+		return val
+	}
+
+	text, _ := recordText(v)
+
+	runes := []rune(text)
+	if keepLast >= len(runes) {
+		return maskPrefix
+	}
+
+	return maskPrefix + string(runes[len(runes)-keepLast:])
+}
+
+// Transform apply the transforms in order to the record, changing it in place, and return the names of the
+// transforms which matched, along with the transforms skipped as their when expression failed to evaluate
+func (rs *RuleSet) Transform(evt map[string]interface{}) ([]string, ExpressionErrors, error) {
+	var (
+		applied  []string
+		exprErrs ExpressionErrors
+	)
+
+	for _, ct := range rs.transforms {
+		match, err := ct.apply(evt)
+		if err != nil {
+			var exprErr *expressionError
+			if !errors.As(err, &exprErr) {
+				return nil, nil, fmt.Errorf("transform %s: %w", ct.name, err)
+			}
+
+			exprErrs.add(ct.name, exprErr)
+		}
+
+		if match {
+			applied = append(applied, ct.name)
+		}
+	}
+
+	return applied, exprErrs, nil
+}
+
+// transform return the transform the path refers to, if any
+func (cr *Configuration) transform(segs []string) *Transform {
+	if len(segs) == 0 {
+		return nil
+	}
+
+	key, idx := splitSegment(segs[0])
+	if key != "transforms" || idx < 0 || idx >= len(cr.Transforms) {
+		return nil
+	}
+
+	return cr.Transforms[idx]
+}
@@ -0,0 +1,183 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var yamlTransformsConfig = `
+version: 2
+rules:
+  - name: drop_describe
+    matches:
+    - field_name: eventName
+      op: prefix
+      value: Describe
+transforms:
+  - name: ssm_parameter_values
+    matches:
+    - field_name: eventSource
+      op: equals
+      value: ssm.amazonaws.com
+    - field_name: eventName
+      op: equals
+      value: PutParameter
+    fields:
+    - path: requestParameters.value
+      op: remove
+    - path: requestParameters.keyId
+      op: mask
+      keep_last: 4
+  - name: user_data
+    when: record.eventName == "RunInstances"
+    fields:
+    - path: requestParameters.userData
+      op: replace
+      value: REDACTED
+  - name: access_keys
+    fields:
+    - path: responseElements.credentials
+      op: mask
+`
+
+func TestRuleSet_Transform(t *testing.T) {
+	rs, err := LoadAndValidate(yamlTransformsConfig)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		evt  map[string]interface{}
+		want map[string]interface{}
+		tfs  []string
+	}{
+		{
+			name: "should remove and mask fields",
+			evt: map[string]interface{}{
+				"eventSource":       "ssm.amazonaws.com",
+				"eventName":         "PutParameter",
+				"requestParameters": map[string]interface{}{"name": "/app/db", "value": "secret", "keyId": "alias/app-key"},
+			},
+			want: map[string]interface{}{
+				"eventSource":       "ssm.amazonaws.com",
+				"eventName":         "PutParameter",
+				"requestParameters": map[string]interface{}{"name": "/app/db", "keyId": "****-key"},
+			},
+			tfs: []string{"ssm_parameter_values", "access_keys"},
+		},
+		{
+			name: "should replace fields matched by expression",
+			evt: map[string]interface{}{
+				"eventName":         "RunInstances",
+				"requestParameters": map[string]interface{}{"userData": "IyEvYmluL2Jhc2g="},
+			},
+			want: map[string]interface{}{
+				"eventName":         "RunInstances",
+				"requestParameters": map[string]interface{}{"userData": "REDACTED"},
+			},
+			tfs: []string{"user_data", "access_keys"},
+		},
+		{
+			name: "should mask each value in an object",
+			evt: map[string]interface{}{
+				"eventName": "GetSessionToken",
+				"responseElements": map[string]interface{}{
+					"credentials": map[string]interface{}{"accessKeyId": "ASIAEXAMPLE", "expiration": nil},
+				},
+			},
+			want: map[string]interface{}{
+				"eventName": "GetSessionToken",
+				"responseElements": map[string]interface{}{
+					"credentials": map[string]interface{}{"accessKeyId": "****", "expiration": nil},
+				},
+			},
+			tfs: []string{"access_keys"},
+		},
+		{
+			name: "should not change missing fields",
+			evt:  map[string]interface{}{"eventName": "RunInstances"},
+			want: map[string]interface{}{"eventName": "RunInstances"},
+			tfs:  []string{"user_data", "access_keys"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, exprErrs, err := rs.Transform(tt.evt)
+			assert.NoError(err)
+			assert.Nil(exprErrs)
+			assert.Equal(tt.tfs, got)
+			assert.Equal(tt.want, tt.evt)
+		})
+	}
+}
+
+func TestRuleSet_TransformExpressionError(t *testing.T) {
+	assert := require.New(t)
+
+	rs, err := LoadAndValidate(`
+version: 2
+rules: []
+transforms:
+  - name: instance_user_data
+    when: record.requestParameters.instanceType == "t3.micro"
+    fields:
+    - path: requestParameters.userData
+      op: remove
+`)
+	assert.NoError(err)
+
+	got, exprErrs, err := rs.Transform(map[string]interface{}{"eventName": "ConsoleLogin"})
+	assert.NoError(err)
+	assert.Empty(got)
+	assert.Equal(map[string]string{"instance_user_data": "when expression failed: no such key: requestParameters"},
+		expressionErrorMessages(exprErrs))
+}
+
+func TestValidateTransforms(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := Load(`
+version: 2
+rules:
+  - name: drop_describe
+    when: record.eventName.startsWith("Describe")
+transforms:
+  - name: ssm
+    fields:
+    - path: requestParameters.value
+      op: replace
+    - path: requestParameters.keyId
+      op: remove
+      keep_last: 4
+  - name: empty
+  - name: null_field
+    fields: [~]
+  - ~
+`)
+	assert.NoError(err)
+	assert.EqualError(cfg.Validate(), `line 9: transforms[0].fields[0].value: is required when op is replace; `+
+		`line 13: transforms[0].fields[1].keep_last: can only be used when op is mask; `+
+		`line 14: transforms[1].fields: is required; `+
+		`line 16: transforms[2].fields[0]: is required; `+
+		`line 17: transforms[3]: is required`)
+}
+
+func TestMerge_Transforms(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := Merge([]*Document{
+		{Source: "a.yaml", Content: yamlTransformsConfig},
+		{Source: "b.yaml", Content: "version: 2\nrules: []\ntransforms:\n  - name: bad\n    fields:\n    - path: eventName\n      op: hash\n"},
+	})
+	assert.NoError(err)
+	assert.Len(cfg.Transforms, 4)
+	assert.EqualError(cfg.Validate(), `b.yaml: line 7: transforms[0].fields[0].op: "hash" is not valid, must be one of remove, mask, replace`)
+
+	_, err = Merge([]*Document{
+		{Source: "a.yaml", Content: yamlTransformsConfig},
+		{Source: "b.yaml", Content: "version: 2\nrules: []\ntransforms: [~]\n"},
+	})
+	assert.EqualError(err, `b.yaml: line 3: transforms[0]: is required`)
+}
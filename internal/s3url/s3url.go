@@ -0,0 +1,20 @@
+package s3url
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse split a url such as s3://bucket/key into the bucket and key
+func Parse(u string) (string, string, error) {
+	if !strings.HasPrefix(u, "s3://") {
+		return "", "", fmt.Errorf("invalid s3 url %q, expected s3://bucket/key", u)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(u, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid s3 url %q, expected s3://bucket/key", u)
+	}
+
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,47 @@
+package s3url
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		u          string
+		wantBucket string
+		wantKey    string
+		wantErr    string
+	}{
+		{
+			name:       "should split bucket and key",
+			u:          "s3://config-bucket/lists/partners.yaml",
+			wantBucket: "config-bucket",
+			wantKey:    "lists/partners.yaml",
+		},
+		{
+			name:    "should reject missing scheme",
+			u:       "config-bucket/partners.yaml",
+			wantErr: `invalid s3 url "config-bucket/partners.yaml", expected s3://bucket/key`,
+		},
+		{
+			name:    "should reject missing key",
+			u:       "s3://config-bucket/",
+			wantErr: `invalid s3 url "s3://config-bucket/", expected s3://bucket/key`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, key, err := Parse(tt.u)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.wantBucket, bucket)
+			require.Equal(t, tt.wantKey, key)
+		})
+	}
+}
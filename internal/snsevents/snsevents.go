@@ -20,11 +20,16 @@ type Processor struct {
 }
 
 // NewProcessor setup a new s3 event processor
-func NewProcessor(cfg flags.S3Processor, awscfg *aws.Config) *Processor {
+func NewProcessor(cfg flags.S3Processor, awscfg *aws.Config) (*Processor, error) {
+	copier, err := cloudtrailprocessor.NewCopier(cfg, awscfg)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Processor{
 		cfg:    cfg,
-		copier: cloudtrailprocessor.NewCopier(cfg, awscfg),
-	}
+		copier: copier,
+	}, nil
 }
 
 // Handler send s3 events to sns
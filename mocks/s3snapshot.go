@@ -0,0 +1,77 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/wolfeidau/cloudtrail-log-processor/internal/rules (interfaces: S3SnapshotAPI)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	request "github.com/aws/aws-sdk-go/aws/request"
+	s3 "github.com/aws/aws-sdk-go/service/s3"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockS3SnapshotAPI is a mock of S3SnapshotAPI interface.
+type MockS3SnapshotAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockS3SnapshotAPIMockRecorder
+}
+
+// MockS3SnapshotAPIMockRecorder is the mock recorder for MockS3SnapshotAPI.
+type MockS3SnapshotAPIMockRecorder struct {
+	mock *MockS3SnapshotAPI
+}
+
+// NewMockS3SnapshotAPI creates a new mock instance.
+func NewMockS3SnapshotAPI(ctrl *gomock.Controller) *MockS3SnapshotAPI {
+	mock := &MockS3SnapshotAPI{ctrl: ctrl}
+	mock.recorder = &MockS3SnapshotAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockS3SnapshotAPI) EXPECT() *MockS3SnapshotAPIMockRecorder {
+	return m.recorder
+}
+
+// GetObjectWithContext mocks base method.
+func (m *MockS3SnapshotAPI) GetObjectWithContext(arg0 context.Context, arg1 *s3.GetObjectInput, arg2 ...request.Option) (*s3.GetObjectOutput, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetObjectWithContext", varargs...)
+	ret0, _ := ret[0].(*s3.GetObjectOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetObjectWithContext indicates an expected call of GetObjectWithContext.
+func (mr *MockS3SnapshotAPIMockRecorder) GetObjectWithContext(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetObjectWithContext", reflect.TypeOf((*MockS3SnapshotAPI)(nil).GetObjectWithContext), varargs...)
+}
+
+// PutObjectWithContext mocks base method.
+func (m *MockS3SnapshotAPI) PutObjectWithContext(arg0 context.Context, arg1 *s3.PutObjectInput, arg2 ...request.Option) (*s3.PutObjectOutput, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PutObjectWithContext", varargs...)
+	ret0, _ := ret[0].(*s3.PutObjectOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PutObjectWithContext indicates an expected call of PutObjectWithContext.
+func (mr *MockS3SnapshotAPIMockRecorder) PutObjectWithContext(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutObjectWithContext", reflect.TypeOf((*MockS3SnapshotAPI)(nil).PutObjectWithContext), varargs...)
+}
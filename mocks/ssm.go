@@ -0,0 +1,56 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/wolfeidau/cloudtrail-log-processor/internal/rules (interfaces: SSMPathAPI)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	request "github.com/aws/aws-sdk-go/aws/request"
+	ssm "github.com/aws/aws-sdk-go/service/ssm"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockSSMPathAPI is a mock of SSMPathAPI interface.
+type MockSSMPathAPI struct {
+	ctrl     *gomock.Controller
+	recorder *MockSSMPathAPIMockRecorder
+}
+
+// MockSSMPathAPIMockRecorder is the mock recorder for MockSSMPathAPI.
+type MockSSMPathAPIMockRecorder struct {
+	mock *MockSSMPathAPI
+}
+
+// NewMockSSMPathAPI creates a new mock instance.
+func NewMockSSMPathAPI(ctrl *gomock.Controller) *MockSSMPathAPI {
+	mock := &MockSSMPathAPI{ctrl: ctrl}
+	mock.recorder = &MockSSMPathAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSSMPathAPI) EXPECT() *MockSSMPathAPIMockRecorder {
+	return m.recorder
+}
+
+// GetParametersByPathPagesWithContext mocks base method.
+func (m *MockSSMPathAPI) GetParametersByPathPagesWithContext(arg0 context.Context, arg1 *ssm.GetParametersByPathInput, arg2 func(*ssm.GetParametersByPathOutput, bool) bool, arg3 ...request.Option) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetParametersByPathPagesWithContext", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetParametersByPathPagesWithContext indicates an expected call of GetParametersByPathPagesWithContext.
+func (mr *MockSSMPathAPIMockRecorder) GetParametersByPathPagesWithContext(arg0, arg1, arg2 interface{}, arg3 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetParametersByPathPagesWithContext", reflect.TypeOf((*MockSSMPathAPI)(nil).GetParametersByPathPagesWithContext), varargs...)
+}